@@ -7,8 +7,13 @@ import (
 	"go/format"
 	"go/parser"
 	"go/token"
+	"go/types"
 	"io"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/Unixeno/gootprint/frame"
 	log "github.com/sirupsen/logrus"
@@ -16,41 +21,99 @@ import (
 
 type Parser struct {
 	filename    string
+	fileKey     string // stable file name, relative to the module root
 	sourceFile  io.Reader
+	source      []byte
 	packageName string
 	fSet        *token.FileSet
 	fileNode    *ast.File
 	level       int
 	frameCtx    *frame.Context
+	imports     map[string]string             // names of imported packages => import paths
+	labels      map[ast.Stmt]*ast.LabeledStmt // statements => their labels
+	gotoLabels  map[string]bool               // labels used by goto in the file
+	options     frame.Options
+	pkg         *types.Package         // the package type checked, it's nil if not type checked
+	info        *types.Info            // type information of the package, it's nil if not type checked
+	decls       map[string]ast.ObjKind // package level declarations of other files in the package, loaded on demand
+	iterators   map[string]bool        // functions and methods (as `.Name`) of the package which return function iterators, loaded with decls
 }
 
-func NewParser(filename string) *Parser {
+// NewParser parses a source file on its own, instrumentation rules are decided by syntax
+func NewParser(filename, fileKey string, options frame.Options) *Parser {
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		log.WithError(err).WithField("filename", filename).Fatal("failed to read source file")
+	}
 	fSet := token.NewFileSet()
-	node, err := parser.ParseFile(fSet, filename, nil, 0)
+	node, err := parser.ParseFile(fSet, filename, source, 0)
 	if err != nil {
 		log.WithError(err).WithField("filename", filename).Fatal("failed to parse source file")
 	}
+	return newParser(filename, fileKey, options, source, fSet, node)
+}
+
+// NewTypedParser creates parser for a file of a type checked package,
+// instrumentation rules are decided by resolved types, and by syntax where types are unknown
+func NewTypedParser(filename, fileKey string, options frame.Options, pkg *typedPackage) *Parser {
+	p := newParser(filename, fileKey, options, pkg.sources[filename], pkg.fSet, pkg.files[filename])
+	p.pkg = pkg.pkg
+	p.info = pkg.info
+	return p
+}
+
+func newParser(filename, fileKey string, options frame.Options, source []byte, fSet *token.FileSet, node *ast.File) *Parser {
 	return &Parser{
 		filename: filename,
+		fileKey:  fileKey,
+		source:   source,
 		fSet:     fSet,
 		fileNode: node,
+		imports:  map[string]string{},
+		labels:   map[ast.Stmt]*ast.LabeledStmt{},
+		options:  options,
 	}
 }
 
 func (p *Parser) Parse() {
 	p.packageName = p.fileNode.Name.Name
 	log.Debugf("found package %v", p.packageName)
-	p.frameCtx = frame.NewFrameContext(p.filename, p.packageName, p.getLine(p.fileNode.Pos()), p.getLine(p.fileNode.End()))
+	p.frameCtx = frame.NewFrameContext(p.filename, p.fileKey, p.packageName, p.position(p.fileNode.Name.End()), p.position(p.fileNode.End()))
+	p.frameCtx.SetOptions(p.options)
 	f := p.fileNode
+	p.gotoLabels = findGotoLabels(f)
 	for _, decl := range f.Decls {
 		if funcDecl, ok := decl.(*ast.FuncDecl); ok {
 			p.parseFunc(funcDecl)
 		} else if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.IMPORT {
 			log.Debugf("found import")
 			p.parseImport(genDecl.Specs)
+		} else if ok && (genDecl.Tok == token.VAR || genDecl.Tok == token.CONST) {
+			p.parseValueSpecs(genDecl)
+		}
+	}
+	if p.options.StablePaths {
+		p.frameCtx.StabilizePaths(p.source)
+	}
+}
+
+// parseValueSpecs finds function literals in package level variables, they are parsed as children of package frame:
+// var handler = func() {...}, var defaultX = buildX(func() {...})
+func (p *Parser) parseValueSpecs(genDecl *ast.GenDecl) {
+	suffix := genDecl.Tok.String()
+	for _, spec := range genDecl.Specs {
+		valueSpec, ok := spec.(*ast.ValueSpec)
+		if !ok {
+			continue
+		}
+		for _, value := range valueSpec.Values {
+			if funcCall, ok := value.(*ast.CallExpr); ok {
+				p.parseCallExpr(funcCall, suffix+"-call")
+			} else {
+				p.parseExpr(value, suffix)
+			}
 		}
 	}
-	return
 }
 
 func (p *Parser) parseImport(specs []ast.Spec) {
@@ -58,27 +121,63 @@ func (p *Parser) parseImport(specs []ast.Spec) {
 		if importSpec, ok := spec.(*ast.ImportSpec); ok {
 			log.Debugf("  >> %s", importSpec.Path.Value)
 			p.frameCtx.Import(importSpec.Path.Value)
+			p.imports[importName(importSpec)], _ = strconv.Unquote(importSpec.Path.Value)
 		}
 	}
 }
 
+// importName returns the name to access an imported package, it's guessed from import path
+// if the package is not renamed, such as `yaml` for "gopkg.in/yaml.v2" and `chi` for "github.com/go-chi/chi/v5"
+func importName(spec *ast.ImportSpec) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+	importPath, err := strconv.Unquote(spec.Path.Value)
+	if err != nil {
+		return ""
+	}
+	elements := strings.Split(importPath, "/")
+	name := elements[len(elements)-1]
+	if len(elements) > 1 && len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		name = elements[len(elements)-2] // major version suffix
+	}
+	if index := strings.IndexByte(name, '.'); index > 0 {
+		name = name[:index]
+	}
+	return strings.TrimPrefix(name, "go-")
+}
+
 func (p *Parser) parseFunc(funcDecl *ast.FuncDecl) {
 	funcName := funcDecl.Name.Name
 	fullFuncName := funcName // fullFuncName will contain receiver type when it's a method
 	isReceiver := funcDecl.Recv != nil
 	receiverName := ""
 	if isReceiver {
-		switch recvType := funcDecl.Recv.List[0].Type.(type) {
+		switch recvType := unparen(funcDecl.Recv.List[0].Type).(type) {
 		case *ast.StarExpr:
-			if realRecvType, ok := recvType.X.(*ast.Ident); !ok {
+			if realRecvType, ok := receiverTypeName(recvType.X); !ok {
 				log.Fatalf("unsupported reciver type, too many `*`, at %v", p.fSet.Position(recvType.Pos()))
 			} else {
-				receiverName = "*" + realRecvType.Name
+				receiverName = "*" + realRecvType
+			}
+		default:
+			if realRecvType, ok := receiverTypeName(recvType); !ok {
+				log.Fatalf("unsupported reciver type at %v", p.fSet.Position(recvType.Pos()))
+			} else {
+				receiverName = realRecvType
 			}
-		case *ast.Ident:
-			receiverName = recvType.Name
 		}
 	}
+	if typeParams := funcDecl.Type.TypeParams; typeParams != nil { // func Map[T, U any]()
+		names := make([]string, 0, typeParams.NumFields())
+		for _, field := range typeParams.List {
+			for _, name := range field.Names {
+				names = append(names, name.Name)
+			}
+		}
+		funcName += "[" + strings.Join(names, ",") + "]"
+		fullFuncName = funcName
+	}
 	if isReceiver {
 		log.Debugf("found method (%v)`%v`  from %v to %v", receiverName, funcName,
 			p.fSet.Position(funcDecl.Pos()),
@@ -92,22 +191,74 @@ func (p *Parser) parseFunc(funcDecl *ast.FuncDecl) {
 		)
 	}
 	funcFrame := frame.NewFuncFrame(p.frameCtx.GetInnerName(fullFuncName))
+	if isReceiver {
+		funcFrame.SetQualifiedName(p.packageName + ".(" + receiverName + ")." + funcName)
+	} else {
+		funcFrame.SetQualifiedName(p.packageName + "." + funcName)
+	}
 	if funcDecl.Type.Results != nil {
 		funcFrame.MarkResult()
 	}
-	p.parseBlock(funcDecl.Body, fullFuncName, p.getLine(funcDecl.Pos()), funcFrame)
+	if !isReceiver && p.packageName == "main" && funcName == "main" {
+		funcFrame.MarkEntry()
+	}
+	if !isReceiver && funcName == "init" {
+		funcFrame.MarkInit()
+	}
+	p.parseBlock(funcDecl.Body, fullFuncName, funcDecl.Pos(), funcFrame)
+}
+
+// receiverTypeName returns the name of receiver type without `*`,
+// type parameters are included for generic types: List[T], Map[K,V]
+func receiverTypeName(expr ast.Expr) (string, bool) {
+	var typeName ast.Expr
+	var params []ast.Expr
+	switch typed := unparen(expr).(type) {
+	case *ast.Ident:
+		return typed.Name, true
+	case *ast.IndexExpr: // List[T]
+		typeName = typed.X
+		params = []ast.Expr{typed.Index}
+	case *ast.IndexListExpr: // Map[K, V]
+		typeName = typed.X
+		params = typed.Indices
+	default:
+		return "", false
+	}
+	ident, ok := typeName.(*ast.Ident)
+	if !ok {
+		return "", false
+	}
+	names := make([]string, 0, len(params))
+	for _, param := range params {
+		paramIdent, ok := param.(*ast.Ident)
+		if !ok {
+			return "", false
+		}
+		names = append(names, paramIdent.Name)
+	}
+	return ident.Name + "[" + strings.Join(names, ",") + "]", true
 }
 
 func (p *Parser) getLine(pos token.Pos) int {
 	return p.fSet.Position(pos).Line
 }
 
+func (p *Parser) position(pos token.Pos) token.Position {
+	return p.fSet.Position(pos)
+}
+
+// parseIf parses if statement and all its else-if and else branches, returns false if code after it is unreachable,
+// which means all branches exist and none of them reaches the end
 func (p *Parser) parseIf(stmt *ast.IfStmt) bool {
-	allReturn := true
+	reachable := false
 	for {
+		p.parseSimpleStmt(stmt.Init)
+		p.parseCondition(stmt.Cond)
+		p.parseExpr(stmt.Cond, "if-cond")
 		log.Debugf("%s>>>> found if from pos: %v to %v", p.genPrintPrefix(), p.fSet.Position(stmt.Pos()), p.fSet.Position(stmt.Body.End()))
-		if !p.parseBlock(stmt.Body, "if", p.getLine(stmt.Pos()), frame.NewIfElseFrame(p.frameCtx.GetInnerName("if"))) {
-			allReturn = false
+		if p.parseBlock(stmt.Body, "if", stmt.Pos(), frame.NewIfElseFrame(p.frameCtx.GetInnerName("if"))) {
+			reachable = true
 		}
 
 		if stmt.Else != nil { // else
@@ -116,32 +267,47 @@ func (p *Parser) parseIf(stmt *ast.IfStmt) bool {
 				stmt = typedElse
 			case *ast.BlockStmt: // else
 				log.Debugf("%s>>>> found if-else from pos: %v to %v", p.genPrintPrefix(), p.fSet.Position(typedElse.Pos()), p.fSet.Position(typedElse.End()))
-				if !p.parseBlock(typedElse, "else", p.getLine(typedElse.Pos()), frame.NewIfElseFrame(p.frameCtx.GetInnerName("else"))) {
-					allReturn = false
+				if p.parseBlock(typedElse, "else", typedElse.Pos(), frame.NewIfElseFrame(p.frameCtx.GetInnerName("else"))) {
+					reachable = true
 				}
-				return allReturn
+				return reachable
 			}
 		} else {
 			// miss else
-			return false
+			if p.options.BranchCoverage {
+				log.Debugf("%s>>>> inject else at pos: %v", p.genPrintPrefix(), p.fSet.Position(stmt.Body.End()))
+				p.parseImplicit(frame.NewImplicitElseFrame(p.frameCtx.GetInnerName("else-implicit")), stmt.Pos(), stmt.Body.End())
+			}
+			return true
 		}
 	}
 }
 
-// as switch and select has almost the same structure, we can parse them in the same way
-func (p *Parser) parseSwitchSelect(unionStmt ast.Stmt) {
+// as switch and select has almost the same structure, we can parse them in the same way,
+// returns false if code after it is unreachable
+func (p *Parser) parseSwitchSelect(unionStmt ast.Stmt) bool {
 	var realType string
 	var body *ast.BlockStmt
+	var tagless bool // cases of a switch without tag are conditions
+	var typeSwitch *frame.TypeSwitchFrame
+	var selectStmt *frame.SelectFrame
 	switch typed := unionStmt.(type) {
 	case *ast.SwitchStmt:
 		realType = "switch"
 		body = typed.Body
+		tagless = typed.Tag == nil
+		p.parseSimpleStmt(typed.Init)
+		p.parseExpr(typed.Tag, "switch-tag")
 	case *ast.TypeSwitchStmt:
 		realType = "typed-switch"
 		body = typed.Body
+		p.parseSimpleStmt(typed.Init)
+		typeSwitch = p.parseTypeSwitchGuard(typed.Assign)
+		p.parseSimpleStmt(typed.Assign)
 	case *ast.SelectStmt:
 		realType = "select"
 		body = typed.Body
+		selectStmt = p.parseSelectStmt(typed)
 	default:
 		log.Fatalf("not switch or select")
 	}
@@ -151,6 +317,9 @@ func (p *Parser) parseSwitchSelect(unionStmt ast.Stmt) {
 	// since we only need to track the case, there is no need to create new frame
 	p.level++
 	defer func() { p.level-- }()
+	// a select without default may block forever, so default is only required by switch
+	_, hasDefault := unionStmt.(*ast.SelectStmt)
+	reachable := p.hasBreak(unionStmt)
 	for _, stmt := range body.List {
 		var caseBody []ast.Stmt
 		var headBegin, bodyBegin, bodyEnd token.Pos
@@ -161,9 +330,16 @@ func (p *Parser) parseSwitchSelect(unionStmt ast.Stmt) {
 			} else {
 				log.Debugf("%s>>>> found case at pos: %v", p.genPrintPrefix(), p.fSet.Position(typed.Pos()))
 			}
+			for _, expr := range typed.List {
+				if tagless {
+					p.parseCondition(expr)
+				}
+				p.parseExpr(expr, "case")
+			}
+			hasDefault = hasDefault || typed.List == nil
 			caseBody = typed.Body
 			headBegin = typed.Case
-			bodyBegin = typed.Case
+			bodyBegin = typed.Colon + 1
 			bodyEnd = typed.End()
 		case *ast.CommClause:
 			if typed.Comm == nil {
@@ -171,130 +347,868 @@ func (p *Parser) parseSwitchSelect(unionStmt ast.Stmt) {
 			} else {
 				log.Debugf("%s>>>> found case at pos: %v", p.genPrintPrefix(), p.fSet.Position(typed.Pos()))
 			}
+			p.parseSimpleStmt(typed.Comm)
 			caseBody = typed.Body
 			headBegin = typed.Case
-			bodyBegin = typed.Case
+			bodyBegin = typed.Colon + 1
 			bodyEnd = typed.End()
 		default:
 			log.Fatalf("unexpected element in %s body, %v, %v", realType, p.fSet.Position(stmt.Pos()), reflect.TypeOf(stmt))
 		}
 
 		newFrame := frame.NewCaseFrame(p.frameCtx.GetInnerName(realType))
-		newFrame.SetPosLine(p.getLine(headBegin), p.getLine(bodyBegin), p.getLine(bodyEnd))
+		if typeSwitch != nil {
+			newFrame.MarkTypeSwitch(typeSwitch)
+		} else if selectStmt != nil {
+			newFrame.MarkSelect(selectStmt)
+		}
+		newFrame.SetPos(p.position(headBegin), p.position(bodyBegin), p.position(bodyEnd))
 		p.frameCtx.Push(newFrame)
-		p.parseBlockBody(caseBody, newFrame)
+		if p.parseBlockBody(caseBody, newFrame) {
+			reachable = true
+		}
 		p.frameCtx.Pop()
 	}
+	if !hasDefault && realType != "select" && p.options.BranchCoverage {
+		log.Debugf("%s>>>> inject default at pos: %v", p.genPrintPrefix(), p.fSet.Position(body.Rbrace))
+		p.parseImplicit(frame.NewImplicitDefaultFrame(p.frameCtx.GetInnerName(realType+"-implicit")), unionStmt.Pos(), body.Rbrace)
+	}
+	return reachable || !hasDefault
+}
+
+// parseTypeSwitchGuard creates frame for the guard of type switch, so the type matched can be recorded by cases
+func (p *Parser) parseTypeSwitchGuard(assign ast.Stmt) *frame.TypeSwitchFrame {
+	symbol := ""
+	if assignStmt, ok := assign.(*ast.AssignStmt); ok { // v := x.(type)
+		symbol = assignStmt.Lhs[0].(*ast.Ident).Name
+	}
+	newFrame := frame.NewTypeSwitchFrame(p.frameCtx.GetInnerName("type-guard"), symbol, p.position(assign.Pos()).Offset)
+	newFrame.SetPos(p.position(assign.Pos()), p.position(assign.Pos()), p.position(assign.End()))
+	p.frameCtx.Push(newFrame)
+	p.frameCtx.Pop()
+	return newFrame
 }
 
-// parse single statement, return true if it's a return statement
+// parseSelectStmt creates frame to record the time a select starts, so the time blocked can be recorded by cases.
+// It returns nil if the label of select is a goto target, as the select is wrapped in a block,
+// or if the select has no cases, which blocks forever
+func (p *Parser) parseSelectStmt(stmt *ast.SelectStmt) *frame.SelectFrame {
+	if len(stmt.Body.List) == 0 {
+		return nil
+	}
+	begin := stmt.Pos()
+	if labeled, ok := p.labels[stmt]; ok {
+		if p.gotoLabels[labeled.Label.Name] {
+			return nil
+		}
+		begin = labeled.Pos()
+	}
+	newFrame := frame.NewSelectFrame(p.frameCtx.GetInnerName("select-stmt"), p.position(begin).Offset, p.position(stmt.End()).Offset)
+	newFrame.SetPos(p.position(begin), p.position(stmt.Body.Lbrace+1), p.position(stmt.Body.Rbrace))
+	p.frameCtx.Push(newFrame)
+	p.frameCtx.Pop()
+	return newFrame
+}
+
+// parseImplicit injects a frame for a branch not written in source code at pos,
+// head is the beginning of the statement it belongs to
+func (p *Parser) parseImplicit(implicitFrame *frame.ImplicitFrame, head, pos token.Pos) {
+	implicitFrame.SetPos(p.position(head), p.position(pos), p.position(pos))
+	p.frameCtx.Push(implicitFrame)
+	p.frameCtx.Pop()
+}
+
+// parseStmt parses a single statement, returns false if code after it is unreachable
 func (p *Parser) parseStmt(stmt ast.Stmt, currentFrame frame.Frame) bool {
 	switch typed := stmt.(type) {
 	case *ast.ReturnStmt:
-		// todo: parse function lit in return statement
-		currentFrame.SetReturn(p.getLine(typed.Pos()))
+		for _, result := range typed.Results {
+			p.parseExpr(result, "return")
+		}
 		log.Debugf("%s>>>> found return at pos: %v", p.genPrintPrefix(), p.getLine(typed.End()))
-		return true
+		return false
+	case *ast.BranchStmt: // break, continue, goto and fallthrough
+		return false
 	case *ast.IfStmt:
-		if p.parseIf(typed) {
-			currentFrame.SetUnreachable()
-		}
+		return p.parseIf(typed)
 	case *ast.SwitchStmt:
-		p.parseSwitchSelect(typed)
+		return p.parseSwitchSelect(typed)
 	case *ast.SelectStmt:
-		p.parseSwitchSelect(typed)
+		return p.parseSwitchSelect(typed)
 	case *ast.TypeSwitchStmt:
-		p.parseSwitchSelect(typed)
-	case *ast.LabeledStmt: // todo: check label jump
-		p.parseStmt(typed.Stmt, currentFrame)
+		return p.parseSwitchSelect(typed)
+	case *ast.LabeledStmt:
+		p.labels[typed.Stmt] = typed
+		return p.parseStmt(typed.Stmt, currentFrame)
 	case *ast.RangeStmt:
 		log.Debugf("%s>>>> found for-range at pos: %v", p.genPrintPrefix(), p.fSet.Position(typed.Pos()))
-		p.parseBlock(typed.Body, "for-range", p.getLine(typed.Pos()), frame.NewForFrame(p.frameCtx.GetInnerName("for-range")))
+		p.parseExpr(typed.X, "range")
+		if p.isRangeFunc(typed.X) {
+			return p.parseLoop(typed, typed.Body, "for-range-func", true, true)
+		}
+		return p.parseLoop(typed, typed.Body, "for-range", true, false)
 	case *ast.ForStmt:
 		log.Debugf("%s>>>> found for at pos: %v", p.genPrintPrefix(), p.fSet.Position(typed.Pos()))
-		p.parseBlock(typed.Body, "for", p.getLine(typed.Pos()), frame.NewForFrame(p.frameCtx.GetInnerName("for")))
+		p.parseSimpleStmt(typed.Init)
+		p.parseCondition(typed.Cond)
+		p.parseExpr(typed.Cond, "for-cond")
+		p.parseSimpleStmt(typed.Post)
+		return p.parseLoop(typed, typed.Body, "for", typed.Cond != nil || p.hasBreak(typed), false)
 	case *ast.DeclStmt:
 		if genDecl, ok := typed.Decl.(*ast.GenDecl); ok {
 			for _, spec := range genDecl.Specs {
 				if valueSpec, ok := spec.(*ast.ValueSpec); ok { // var x = func() {}
 					for _, value := range valueSpec.Values {
-						if funcLit, ok := value.(*ast.FuncLit); ok {
-							funcFrame := frame.NewFuncFrame(p.frameCtx.GetInnerName("anonymous-decl-assign"))
-							p.parseBlock(funcLit.Body, "anonymous-decl-assign", p.getLine(funcLit.Pos()), funcFrame)
-						} else if funcCall, ok := value.(*ast.CallExpr); ok {
-							p.parseFuncCallExpr(funcCall, "decl-assign-call")
+						if funcCall, ok := value.(*ast.CallExpr); ok {
+							p.parseCallExpr(funcCall, "decl-assign-call")
+						} else {
+							p.parseExpr(value, "decl-assign")
 						}
 					}
 				}
 			}
 		}
-	case *ast.ExprStmt: // function call
-		if funcCall, ok := typed.X.(*ast.CallExpr); ok { // func(){}()
-			p.parseFuncCallExpr(funcCall, "call")
-		}
+	case *ast.ExprStmt: // function call, func(){}(), <-ch
+		p.parseExpr(typed.X, "call")
+		return !p.isNoReturnCall(typed.X)
 	case *ast.AssignStmt: // function call may exist in the right of assignment
+		for _, expr := range typed.Lhs { // m[f()] = x
+			p.parseExpr(expr, "assign-lhs")
+		}
 		for _, expr := range typed.Rhs {
 			if funcCall, ok := expr.(*ast.CallExpr); ok { // x = func()T{return T}()
-				p.parseFuncCallExpr(funcCall, "assign-call")
-			} else if funcLit, ok := expr.(*ast.FuncLit); ok { // x = func(){}
-				funcFrame := frame.NewFuncFrame(p.frameCtx.GetInnerName("anonymous-assign"))
-				p.parseBlock(funcLit.Body, "anonymous-assign", p.getLine(funcLit.Pos()), funcFrame)
+				p.parseCallExpr(funcCall, "assign-call")
+			} else { // x = func(){}, x = T{Handler: func(){}}
+				p.parseExpr(expr, "assign")
 			}
 		}
+	case *ast.SendStmt: // ch <- func(){}
+		p.parseExpr(typed.Chan, "send")
+		p.parseExpr(typed.Value, "send")
+	case *ast.IncDecStmt:
+		p.parseExpr(typed.X, "inc-dec")
+	case *ast.BlockStmt:
+		reachable, _ := p.parseStmts(typed.List, currentFrame)
+		return reachable
 	case *ast.GoStmt: // go func(){}
-		if funcLit, ok := typed.Call.Fun.(*ast.FuncLit); ok {
+		if funcLit, ok := unparen(typed.Call.Fun).(*ast.FuncLit); ok {
 			log.Debugf("%sfound go func-lit call, at pos: %v", p.genPrintPrefix(), p.fSet.Position(funcLit.Pos()))
 			newGoFrame := frame.NewGoFuncFrame(p.frameCtx.GetInnerName("go-anonymous"))
-			p.parseBlock(funcLit.Body, "anonymous-go", p.getLine(funcLit.Pos()), newGoFrame)
-		} else if ident, ok := typed.Call.Fun.(*ast.Ident); ok {
-			log.Debugf("%sfound go func call, target `%v` at pos: %v", p.genPrintPrefix(), ident.Name, p.fSet.Position(ident.Pos()))
-			newGoFrame := frame.NewGoFuncFrame(p.frameCtx.GetInnerName("go-" + ident.Name))
-			newGoFrame.SetPosLine(p.getLine(typed.Pos()), p.getLine(ident.Pos()), p.getLine(typed.Call.End()))
-			newGoFrame.SetTarget(ident.Name)
+			p.parseBlock(funcLit.Body, "anonymous-go", funcLit.Pos(), newGoFrame)
+		} else { // go f(x), go s.worker(x), go pkg.Serve(x), go h.handlers[k](x), go newWorker()(x)
+			target := typed.Call.Fun
+			log.Debugf("%sfound go func call, target `%v` at pos: %v", p.genPrintPrefix(), p.text(target), p.fSet.Position(target.Pos()))
+			newGoFrame := frame.NewGoFuncFrame(p.frameCtx.GetInnerName("go-" + goTargetName(target)))
+			newGoFrame.SetPos(p.position(typed.Pos()), p.position(target.Pos()), p.position(typed.Call.End()))
+			newGoFrame.SetCall(p.goCall(typed.Go, typed.Call))
 			p.frameCtx.Push(newGoFrame)
 			p.frameCtx.Pop() // inject a frame to track goroutine
+			p.parseExpr(target, "go-target")
 		}
 		// anonymous function may exist as an arguments in a function call: go func(int){}(func()int{}())
-		for _, x := range typed.Call.Args {
-			if call, ok := x.(*ast.CallExpr); ok {
-				p.parseFuncCallExpr(call, "go-args")
+		p.parseCallArgs(typed.Call.Args, "go")
+	case *ast.DeferStmt: // defer func(){}(), defer f(x)
+		deferOffset := p.position(typed.Defer).Offset
+		if funcLit, ok := unparen(typed.Call.Fun).(*ast.FuncLit); ok {
+			log.Debugf("%sfound defer func-lit call, at pos: %v", p.genPrintPrefix(), p.fSet.Position(funcLit.Pos()))
+			newDeferFrame := frame.NewDeferFrame(p.frameCtx.GetInnerName("defer-anonymous"), deferOffset)
+			if funcLit.Type.Results != nil {
+				newDeferFrame.MarkResult()
 			}
+			p.parseBlock(funcLit.Body, "anonymous-defer", typed.Pos(), newDeferFrame)
+		} else {
+			target := typed.Call.Fun
+			log.Debugf("%sfound defer func call, target `%v` at pos: %v", p.genPrintPrefix(), p.text(target), p.fSet.Position(target.Pos()))
+			newDeferFrame := frame.NewDeferFrame(p.frameCtx.GetInnerName("defer-"+goTargetName(target)), deferOffset)
+			newDeferFrame.SetPos(p.position(typed.Pos()), p.position(target.Pos()), p.position(typed.Call.End()))
+			newDeferFrame.SetCallEnd(p.position(typed.Call.End()))
+			p.frameCtx.Push(newDeferFrame)
+			p.frameCtx.Pop()
+			p.parseExpr(target, "defer-target")
+		}
+		p.parseCallArgs(typed.Call.Args, "defer")
+	}
+	return true
+}
+
+// goCall collects the source of a go statement, so it can be rewritten without changing
+// the evaluation of function value and arguments
+func (p *Parser) goCall(keyword token.Pos, callExpr *ast.CallExpr) frame.GoCall {
+	call := frame.GoCall{
+		Go:       p.position(keyword).Offset,
+		End:      p.position(callExpr.End()).Offset,
+		Fun:      p.goArg(callExpr.Fun, !p.isStaticFunc(callExpr.Fun)),
+		Ellipsis: callExpr.Ellipsis.IsValid(),
+	}
+	for i, arg := range callExpr.Args {
+		goArg := p.goArg(arg, !p.isConstArg(arg))
+		if goArg.Bind {
+			goArg.Type = p.paramType(callExpr, i)
+		}
+		call.Args = append(call.Args, goArg)
+	}
+	return call
+}
+
+func (p *Parser) goArg(expr ast.Expr, bind bool) frame.GoArg {
+	return frame.GoArg{
+		Expr:   p.text(expr),
+		Offset: p.position(expr.Pos()).Offset,
+		End:    p.position(expr.End()).Offset,
+		Bind:   bind,
+	}
+}
+
+// text returns the source code of a node
+func (p *Parser) text(node ast.Node) string {
+	return string(p.source[p.fSet.Position(node.Pos()).Offset:p.fSet.Position(node.End()).Offset])
+}
+
+// isStaticFunc reports whether expr is a declared function, which can be called later without being evaluated.
+// Identifiers not resolved in current file are looked up in other files of the package, or builtin functions
+func (p *Parser) isStaticFunc(expr ast.Expr) bool {
+	if obj := p.referredObject(expr); obj != nil {
+		switch obj.(type) {
+		case *types.Func, *types.Builtin:
+			return true
+		}
+		return false
+	}
+	if sel, ok := unparen(expr).(*ast.SelectorExpr); ok && p.info != nil {
+		if selection, ok := p.info.Selections[sel]; ok { // T.Method is static, but x.Method evaluates x
+			return selection.Kind() == types.MethodExpr
+		}
+	}
+	switch typed := unparen(expr).(type) {
+	case *ast.Ident:
+		if typed.Obj == nil {
+			kind, ok := p.packageDecl(typed.Name)
+			return ok && kind == ast.Fun || !ok && builtinFuncs[typed.Name]
+		}
+		return typed.Obj.Kind == ast.Fun
+	case *ast.SelectorExpr: // pkg.Func
+		if x, ok := typed.X.(*ast.Ident); ok && x.Obj == nil {
+			_, ok := p.imports[x.Name]
+			return ok
 		}
 	}
 	return false
 }
 
-// parse a block, return true if it ends with a return statement
+// goTargetName returns a readable name of the target of go statement, which is used in frame path
+func goTargetName(expr ast.Expr) string {
+	switch typed := unparen(expr).(type) {
+	case *ast.Ident:
+		return typed.Name
+	case *ast.SelectorExpr:
+		return typed.Sel.Name
+	case *ast.IndexExpr: // handlers[k] or generic function
+		return goTargetName(typed.X)
+	case *ast.IndexListExpr: // generic function
+		return goTargetName(typed.X)
+	case *ast.CallExpr:
+		return goTargetName(typed.Fun) + "-result"
+	}
+	return "func"
+}
+
+func unparen(expr ast.Expr) ast.Expr {
+	for {
+		paren, ok := expr.(*ast.ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = paren.X
+	}
+}
+
+// builtinFuncs are predeclared functions, they can't be used as values
+var builtinFuncs = map[string]bool{
+	"append": true, "cap": true, "clear": true, "close": true, "complex": true, "copy": true, "delete": true,
+	"imag": true, "len": true, "make": true, "max": true, "min": true, "new": true, "panic": true,
+	"print": true, "println": true, "real": true, "recover": true,
+}
+
+// builtinConsts are predeclared constants and nil, they may be untyped
+var builtinConsts = map[string]bool{
+	"true":  true,
+	"false": true,
+	"iota":  true,
+	"nil":   true,
+}
+
+// isConstExpr reports whether expr looks like a constant expression, it's evaluated at compile time
+// and may be untyped, so it must not be assigned to a temporary variable.
+// Identifiers not resolved in current file are looked up in other files of the package, or predeclared constants.
+// Qualified identifiers of imported packages may be constants, such as math.Pi, and shifts of a constant are untyped
+// if the constant is, such as 1 << n, so they are not assigned either, which are evaluated in the new goroutine
+func (p *Parser) isConstExpr(expr ast.Expr) bool {
+	switch typed := expr.(type) {
+	case *ast.BasicLit:
+		return true
+	case *ast.Ident:
+		if typed.Obj == nil {
+			kind, ok := p.packageDecl(typed.Name)
+			return ok && kind == ast.Con || !ok && builtinConsts[typed.Name]
+		}
+		return typed.Obj.Kind == ast.Con
+	case *ast.ParenExpr:
+		return p.isConstExpr(typed.X)
+	case *ast.UnaryExpr:
+		return typed.Op != token.AND && typed.Op != token.ARROW && p.isConstExpr(typed.X)
+	case *ast.SelectorExpr:
+		if x, ok := typed.X.(*ast.Ident); ok && x.Obj == nil {
+			_, declared := p.packageDecl(x.Name)
+			_, imported := p.imports[x.Name]
+			return imported && !declared
+		}
+	case *ast.BinaryExpr:
+		if typed.Op == token.SHL || typed.Op == token.SHR {
+			return p.isConstExpr(typed.X)
+		}
+		return p.isConstExpr(typed.X) && p.isConstExpr(typed.Y)
+	}
+	return false
+}
+
+// packageDecl returns the kind of a package level declaration in other files of the package,
+// files are parsed on the first lookup, test files are skipped as they can't be referred by source files.
+// Backups of files already replaced by generated files are parsed instead
+func (p *Parser) packageDecl(name string) (ast.ObjKind, bool) {
+	p.loadPackageDecls()
+	kind, ok := p.decls[name]
+	return kind, ok
+}
+
+// loadPackageDecls parses other files of the package once, iterator functions are collected from the file itself as well
+func (p *Parser) loadPackageDecls() {
+	if p.decls == nil {
+		p.decls = map[string]ast.ObjKind{}
+		p.iterators = map[string]bool{}
+		p.addIterators(p.fileNode)
+		filenames, _ := filepath.Glob(filepath.Join(filepath.Dir(p.filename), "*.go"))
+		backups, _ := filepath.Glob(filepath.Join(filepath.Dir(p.filename), "*.go.gen_bak"))
+		for _, filename := range append(filenames, backups...) {
+			if filename == p.filename || strings.HasSuffix(filename, "_test.go") || strings.HasSuffix(filename, ".gen.go") {
+				continue
+			}
+			f, err := parser.ParseFile(token.NewFileSet(), filename, nil, 0)
+			if err != nil || f.Name.Name != p.packageName {
+				continue
+			}
+			for name, obj := range f.Scope.Objects {
+				p.decls[name] = obj.Kind
+			}
+			p.addIterators(f)
+		}
+	}
+}
+
+// addIterators collects functions and methods of a file whose only result is a function iterator,
+// methods are keyed by `.Name`, as the type of receiver is unknown at call sites without type information
+func (p *Parser) addIterators(f *ast.File) {
+	imports := map[string]string{}
+	for _, importSpec := range f.Imports {
+		imports[importName(importSpec)], _ = strconv.Unquote(importSpec.Path.Value)
+	}
+	for _, decl := range f.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || !returnsIterator(funcDecl, imports) {
+			continue
+		}
+		if funcDecl.Recv != nil {
+			p.iterators["."+funcDecl.Name.Name] = true
+		} else {
+			p.iterators[funcDecl.Name.Name] = true
+		}
+	}
+}
+
+// isPackageIterator reports whether a function, or a method as `.Name`, of the package returns a function iterator
+func (p *Parser) isPackageIterator(name string) bool {
+	p.loadPackageDecls()
+	return p.iterators[name]
+}
+
+// isConstArg reports whether an argument must not be assigned to a temporary variable,
+// with type information it's a constant, nil or other untyped values such as the result of comparison,
+// which may be converted to a named type of the parameter
+func (p *Parser) isConstArg(expr ast.Expr) bool {
+	if tv, ok := p.typeOf(expr); ok {
+		basic, untyped := tv.Type.(*types.Basic)
+		return tv.Value != nil || tv.IsNil() || untyped && basic.Info()&types.IsUntyped != 0
+	}
+	return p.isConstExpr(expr)
+}
+
+// parseBlockBody parses statements of a block frame, returns false if the end of the block is unreachable.
+// If the block ends with a reachable jump, the ending of the frame is moved to the jump, as code can't be injected after it,
+// otherwise the ending of the frame is marked unreachable, such as a return after log.Fatal
 func (p *Parser) parseBlockBody(stmts []ast.Stmt, currentFrame frame.Frame) bool {
+	reachable, lastReached := p.parseStmts(stmts, currentFrame)
+	if reachable {
+		return true
+	}
+	if jump := p.jumpStmt(stmts[len(stmts)-1]); jump != nil && lastReached {
+		currentFrame.SetReturn(p.position(jump.Pos()))
+	} else {
+		currentFrame.SetUnreachable()
+	}
+	return false
+}
+
+// parseStmts parses a list of statements, returns whether the end of the list is reachable, and whether the last statement is.
+// Statements after a terminating statement are still parsed, as a labeled statement may be the target of goto,
+// so code after a label is treated as reachable
+func (p *Parser) parseStmts(stmts []ast.Stmt, currentFrame frame.Frame) (reachable, lastReached bool) {
+	reachable = true
 	for _, stmt := range stmts {
-		if p.parseStmt(stmt, currentFrame) {
-			return true
+		if _, ok := stmt.(*ast.LabeledStmt); ok {
+			reachable = true
+		}
+		lastReached = reachable
+		if !p.parseStmt(stmt, currentFrame) {
+			reachable = false
+		}
+	}
+	return reachable, lastReached
+}
+
+// jumpStmt returns the statement which transfers control at the end of stmt:
+// return, break, continue, goto, fallthrough and calls never return
+func (p *Parser) jumpStmt(stmt ast.Stmt) ast.Stmt {
+	switch typed := stmt.(type) {
+	case *ast.ReturnStmt, *ast.BranchStmt:
+		return stmt
+	case *ast.ExprStmt:
+		if p.isNoReturnCall(typed.X) {
+			return stmt
+		}
+	case *ast.LabeledStmt:
+		return p.jumpStmt(typed.Stmt)
+	case *ast.BlockStmt:
+		if len(typed.List) > 0 {
+			return p.jumpStmt(typed.List[len(typed.List)-1])
+		}
+	}
+	return nil
+}
+
+// noReturnFuncs are functions never return, by panicking or exiting
+var noReturnFuncs = map[string]bool{
+	"os.Exit":                            true,
+	"runtime.Goexit":                     true,
+	"log.Fatal":                          true,
+	"log.Fatalf":                         true,
+	"log.Fatalln":                        true,
+	"log.Panic":                          true,
+	"log.Panicf":                         true,
+	"log.Panicln":                        true,
+	"github.com/sirupsen/logrus.Fatal":   true,
+	"github.com/sirupsen/logrus.Fatalf":  true,
+	"github.com/sirupsen/logrus.Fatalln": true,
+	"github.com/sirupsen/logrus.Panic":   true,
+	"github.com/sirupsen/logrus.Panicf":  true,
+	"github.com/sirupsen/logrus.Panicln": true,
+	// methods are only resolved with type information
+	"(*log.Logger).Fatal":                          true,
+	"(*log.Logger).Fatalf":                         true,
+	"(*log.Logger).Fatalln":                        true,
+	"(*log.Logger).Panic":                          true,
+	"(*log.Logger).Panicf":                         true,
+	"(*log.Logger).Panicln":                        true,
+	"(*github.com/sirupsen/logrus.Logger).Fatal":   true,
+	"(*github.com/sirupsen/logrus.Logger).Fatalf":  true,
+	"(*github.com/sirupsen/logrus.Logger).Fatalln": true,
+	"(*github.com/sirupsen/logrus.Logger).Panic":   true,
+	"(*github.com/sirupsen/logrus.Logger).Panicf":  true,
+	"(*github.com/sirupsen/logrus.Logger).Panicln": true,
+	"(*github.com/sirupsen/logrus.Entry).Fatal":    true,
+	"(*github.com/sirupsen/logrus.Entry).Fatalf":   true,
+	"(*github.com/sirupsen/logrus.Entry).Fatalln":  true,
+	"(*github.com/sirupsen/logrus.Entry).Panic":    true,
+	"(*github.com/sirupsen/logrus.Entry).Panicf":   true,
+	"(*github.com/sirupsen/logrus.Entry).Panicln":  true,
+}
+
+// isNoReturnCall reports whether expr is a call of builtin panic or a function never returns
+func (p *Parser) isNoReturnCall(expr ast.Expr) bool {
+	callExpr, ok := unparen(expr).(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	if fn := p.calledFunc(callExpr); fn != nil {
+		return noReturnFuncs[fn.FullName()]
+	}
+	if builtin, ok := p.referredObject(callExpr.Fun).(*types.Builtin); ok {
+		return builtin.Name() == "panic"
+	}
+	switch fun := unparen(callExpr.Fun).(type) {
+	case *ast.Ident:
+		return fun.Name == "panic" && fun.Obj == nil
+	case *ast.SelectorExpr:
+		if x, ok := fun.X.(*ast.Ident); ok && x.Obj == nil {
+			importPath, ok := p.imports[x.Name]
+			return ok && noReturnFuncs[importPath+"."+fun.Sel.Name]
 		}
 	}
 	return false
 }
 
-func (p *Parser) parseFuncCallExpr(callExpr *ast.CallExpr, suffix string) {
-	if funcLit, ok := callExpr.Fun.(*ast.FuncLit); ok {
-		p.parseBlock(funcLit.Body, "anonymous-"+suffix, p.getLine(funcLit.Pos()),
-			frame.NewFuncFrame(p.frameCtx.GetInnerName("anonymous-"+suffix)))
+// iteratorFuncs are functions in standard library return function iterators
+var iteratorFuncs = map[string]bool{
+	"slices.All":            true,
+	"slices.Values":         true,
+	"slices.Backward":       true,
+	"slices.Chunk":          true,
+	"maps.All":              true,
+	"maps.Keys":             true,
+	"maps.Values":           true,
+	"strings.Lines":         true,
+	"strings.SplitSeq":      true,
+	"strings.SplitAfterSeq": true,
+	"strings.FieldsSeq":     true,
+	"strings.FieldsFuncSeq": true,
+	"bytes.Lines":           true,
+	"bytes.SplitSeq":        true,
+	"bytes.SplitAfterSeq":   true,
+	"bytes.FieldsSeq":       true,
+	"bytes.FieldsFuncSeq":   true,
+}
+
+// isRangeFunc reports whether a range loop iterates a function, it's only possible since go 1.23.
+// With type information it's decided by the type of range expression, without it, the range expression is resolved by syntax: function literals,
+// objects declared in the file with a function type or iter.Seq, calls of known iterator functions of the standard library,
+// and calls of functions and methods declared in the package which return iterators. A method is matched by name only,
+// and methods of other packages, such as `for x := range pkg.New().All()`, are not recognized without -types
+func (p *Parser) isRangeFunc(x ast.Expr) bool {
+	if tv, ok := p.typeOf(x); ok {
+		_, isFunc := tv.Type.Underlying().(*types.Signature)
+		return isFunc
+	}
+	if !supportRangeFunc(p.fileNode.GoVersion) {
+		return false
+	}
+	switch typed := unparen(x).(type) {
+	case *ast.FuncLit:
+		return true
+	case *ast.Ident: // seq, declared as parameter or variable with type
+		if typed.Obj == nil {
+			return false
+		}
+		switch decl := typed.Obj.Decl.(type) {
+		case *ast.Field:
+			return isIteratorType(decl.Type, p.imports)
+		case *ast.ValueSpec:
+			return decl.Type != nil && isIteratorType(decl.Type, p.imports)
+		}
+	case *ast.CallExpr:
+		switch fun := unparen(typed.Fun).(type) {
+		case *ast.Ident: // function declared in the package, returns an iterator
+			if fun.Obj == nil {
+				return p.isPackageIterator(fun.Name)
+			}
+			if funcDecl, ok := fun.Obj.Decl.(*ast.FuncDecl); ok {
+				return returnsIterator(funcDecl, p.imports)
+			}
+		case *ast.SelectorExpr:
+			if pkg, ok := fun.X.(*ast.Ident); ok && pkg.Obj == nil {
+				if importPath, ok := p.imports[pkg.Name]; ok {
+					return iteratorFuncs[importPath+"."+fun.Sel.Name]
+				}
+			}
+			return p.isPackageIterator("." + fun.Sel.Name) // method declared in the package
+		}
+	}
+	return false
+}
+
+// returnsIterator reports whether the only result of a function is a function iterator, imports are of the file declares it
+func returnsIterator(funcDecl *ast.FuncDecl, imports map[string]string) bool {
+	results := funcDecl.Type.Results
+	return results != nil && len(results.List) == 1 && len(results.List[0].Names) <= 1 && isIteratorType(results.List[0].Type, imports)
+}
+
+// isIteratorType reports whether a type expression is a function iterator,
+// iter.Seq, iter.Seq2 or a function accepts a yield function
+func isIteratorType(typ ast.Expr, imports map[string]string) bool {
+	switch typed := unparen(typ).(type) {
+	case *ast.IndexExpr: // iter.Seq[V]
+		return isIteratorType(typed.X, imports)
+	case *ast.IndexListExpr: // iter.Seq2[K, V]
+		return isIteratorType(typed.X, imports)
+	case *ast.SelectorExpr:
+		if pkg, ok := typed.X.(*ast.Ident); ok && pkg.Obj == nil && imports[pkg.Name] == "iter" {
+			return typed.Sel.Name == "Seq" || typed.Sel.Name == "Seq2"
+		}
+	case *ast.FuncType: // func(yield func(V) bool)
+		if typed.Params == nil || len(typed.Params.List) != 1 || typed.Results != nil {
+			return false
+		}
+		yield, ok := unparen(typed.Params.List[0].Type).(*ast.FuncType)
+		return ok && yield.Results != nil && len(yield.Results.List) == 1
+	}
+	return false
+}
+
+// hasBreak reports whether a break statement refers to stmt, which is a for, switch or select statement,
+// an unlabeled break refers to the innermost one
+func (p *Parser) hasBreak(stmt ast.Stmt) bool {
+	label := p.labelName(stmt)
+	found := false
+	var inspect func(root ast.Stmt, implicit bool)
+	inspect = func(root ast.Stmt, implicit bool) {
+		ast.Inspect(root, func(node ast.Node) bool {
+			switch typed := node.(type) {
+			case *ast.FuncLit:
+				return false
+			case *ast.BranchStmt:
+				if typed.Tok == token.BREAK && (typed.Label == nil && implicit || typed.Label != nil && typed.Label.Name == label) {
+					found = true
+				}
+			case *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt:
+				if node != root {
+					if label != "" {
+						inspect(typed.(ast.Stmt), false)
+					}
+					return false
+				}
+			}
+			return !found
+		})
+	}
+	inspect(stmt, true)
+	return found
+}
+
+// labelName returns the label of a statement, or empty if it's not labeled
+func (p *Parser) labelName(stmt ast.Stmt) string {
+	if labeled, ok := p.labels[stmt]; ok {
+		return labeled.Label.Name
+	}
+	return ""
+}
+
+// findGotoLabels finds labels which are targets of goto
+func findGotoLabels(file *ast.File) map[string]bool {
+	labels := map[string]bool{}
+	ast.Inspect(file, func(node ast.Node) bool {
+		if branch, ok := node.(*ast.BranchStmt); ok && branch.Tok == token.GOTO {
+			labels[branch.Label.Name] = true
+		}
+		return true
+	})
+	return labels
+}
+
+// parseLoop parses the body of for and for-range, reachable tells whether code after the loop is reachable.
+// In loop mode the loop is wrapped in a block with an iteration counter, so are function iterators in any mode,
+// as their body runs in the yield function called by the iterator. Loops whose label is a goto target are
+// not wrapped, as goto cannot jump into a block
+func (p *Parser) parseLoop(loop ast.Stmt, body *ast.BlockStmt, name string, reachable, iterator bool) bool {
+	label := p.labelName(loop)
+	if !p.options.LoopCount && !iterator || label != "" && p.gotoLabels[label] {
+		p.parseBlock(body, name, loop.Pos(), frame.NewForFrame(p.frameCtx.GetInnerName(name)))
+		return reachable
 	}
-	// the parameter of the function call may also come from an anonymous function,
-	// but no one would write it like that :)
-	for _, arg := range callExpr.Args {
+	begin := loop.Pos()
+	if labeled, ok := p.labels[loop]; ok {
+		begin = labeled.Pos()
+	}
+	loopFrame := frame.NewLoopFrame(p.frameCtx.GetInnerName(name), p.position(begin).Offset, p.position(loop.End()).Offset)
+	if reachable {
+		loopFrame.MarkReachable()
+	}
+	if iterator {
+		loopFrame.MarkIterator()
+	}
+	breaks, returns, jumps := p.loopExits(loop, body)
+	for _, pos := range breaks {
+		loopFrame.AddExit(p.position(pos).Offset, frame.LoopBreak)
+	}
+	for _, pos := range returns {
+		loopFrame.AddExit(p.position(pos).Offset, frame.LoopReturn)
+	}
+	for _, pos := range jumps {
+		loopFrame.AddExit(p.position(pos).Offset, frame.LoopJump)
+	}
+	p.parseBlock(body, name, loop.Pos(), loopFrame)
+	return reachable
+}
+
+// loopExits finds statements in the body which leave the loop:
+// breaks of the loop itself, returns, and jumps to labels outside the loop by goto, break and continue.
+// Function literals are skipped, and breaks without label in nested for, switch and select are not exits
+func (p *Parser) loopExits(loop ast.Stmt, body *ast.BlockStmt) (breaks, returns, jumps []token.Pos) {
+	label := p.labelName(loop)
+	inner := map[string]bool{} // labels inside the loop
+	ast.Inspect(body, func(node ast.Node) bool {
+		switch typed := node.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.LabeledStmt:
+			inner[typed.Label.Name] = true
+		}
+		return true
+	})
+	var inspect func(root ast.Node, nested bool)
+	inspect = func(root ast.Node, nested bool) {
+		ast.Inspect(root, func(node ast.Node) bool {
+			switch typed := node.(type) {
+			case *ast.FuncLit:
+				return false
+			case *ast.ReturnStmt:
+				returns = append(returns, typed.Pos())
+			case *ast.BranchStmt:
+				switch {
+				case typed.Tok == token.FALLTHROUGH:
+				case typed.Label == nil:
+					if typed.Tok == token.BREAK && !nested {
+						breaks = append(breaks, typed.Pos())
+					}
+				case typed.Label.Name == label: // continue of the loop is not an exit
+					if typed.Tok == token.BREAK {
+						breaks = append(breaks, typed.Pos())
+					}
+				case !inner[typed.Label.Name]:
+					jumps = append(jumps, typed.Pos())
+				}
+			case *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt:
+				if !nested {
+					inspect(node, true)
+					return false
+				}
+			}
+			return true
+		})
+	}
+	inspect(body, false)
+	return breaks, returns, jumps
+}
+
+func (p *Parser) parseSimpleStmt(stmt ast.Stmt) {
+	if stmt != nil {
+		p.parseStmt(stmt, p.frameCtx.GetCurrent())
+	}
+}
+
+// parseExpr finds function literals and recover calls in an expression at any depth,
+// function literals nested in another function literal are parsed with their parent
+func (p *Parser) parseExpr(expr ast.Node, suffix string) {
+	if expr == nil {
+		return
+	}
+	ast.Inspect(expr, func(node ast.Node) bool {
+		switch typed := node.(type) {
+		case *ast.FuncLit:
+			log.Debugf("%s>>>> found func-lit at pos: %v", p.genPrintPrefix(), p.fSet.Position(typed.Pos()))
+			p.parseFuncLit(typed, "anonymous-"+suffix)
+			return false
+		case *ast.CallExpr:
+			p.parseCallExpr(typed, suffix)
+			return false
+		}
+		return true
+	})
+}
+
+// parseCallExpr parses a function call, function literal can be called directly, be an argument as a callback,
+// or the result of an argument: func(){}(), sort.Slice(x, func(i, j int) bool {...}), f(func() int {...}())
+func (p *Parser) parseCallExpr(callExpr *ast.CallExpr, suffix string) {
+	if isRecoverCall(callExpr) {
+		p.parseRecover(callExpr)
+		return
+	}
+	if funcLit, ok := unparen(callExpr.Fun).(*ast.FuncLit); ok {
+		p.parseFuncLit(funcLit, "anonymous-"+suffix)
+	} else { // makeHandler(func(){})(w)
+		p.parseExpr(callExpr.Fun, suffix)
+	}
+	p.parseCallArgs(callExpr.Args, suffix)
+}
+
+func (p *Parser) parseCallArgs(args []ast.Expr, suffix string) {
+	for _, arg := range args {
 		if x, ok := arg.(*ast.CallExpr); ok {
-			p.parseFuncCallExpr(x, suffix+"-args")
+			p.parseCallExpr(x, suffix+"-args")
+		} else {
+			p.parseExpr(arg, suffix+"-arg")
+		}
+	}
+}
+
+// isRecoverCall reports whether a call is a call of builtin recover
+func isRecoverCall(callExpr *ast.CallExpr) bool {
+	ident, ok := unparen(callExpr.Fun).(*ast.Ident)
+	return ok && ident.Name == "recover" && ident.Obj == nil && len(callExpr.Args) == 0
+}
+
+// parseRecover injects a frame to track a call of builtin recover.
+// Calls deferred directly are not tracked, as recover is evaluated there before it's deferred
+func (p *Parser) parseRecover(callExpr *ast.CallExpr) {
+	if _, ok := p.frameCtx.GetCurrent().(*frame.PackageFrame); ok { // var x = recover(), it's always nil
+		return
+	}
+	log.Debugf("%s>>>> found recover at pos: %v", p.genPrintPrefix(), p.fSet.Position(callExpr.Pos()))
+	newFrame := frame.NewRecoverFrame(p.frameCtx.GetInnerName("recover"))
+	newFrame.SetPos(p.position(callExpr.Pos()), p.position(callExpr.Pos()), p.position(callExpr.End()))
+	p.frameCtx.Push(newFrame)
+	p.frameCtx.Pop()
+}
+
+// parseCondition records operands of a condition in condition coverage mode,
+// it must be called before parsing the expression, so operands are wrapped outside of inner frames
+func (p *Parser) parseCondition(cond ast.Expr) {
+	if cond == nil || !p.options.ConditionCoverage {
+		return
+	}
+	if _, ok := p.frameCtx.GetCurrent().(*frame.PackageFrame); ok {
+		return
+	}
+	log.Debugf("%s>>>> found condition at pos: %v", p.genPrintPrefix(), p.fSet.Position(cond.Pos()))
+	newFrame := frame.NewConditionFrame(p.frameCtx.GetInnerName("cond"), p.conditionOperands(cond, nil))
+	newFrame.SetPos(p.position(cond.Pos()), p.position(cond.Pos()), p.position(cond.End()))
+	p.frameCtx.Push(newFrame)
+	p.frameCtx.Pop()
+}
+
+// conditionOperands splits a condition by && and ||, parentheses and negations are kept outside of operands
+func (p *Parser) conditionOperands(expr ast.Expr, operands []frame.Operand) []frame.Operand {
+	switch typed := expr.(type) {
+	case *ast.BinaryExpr:
+		if typed.Op == token.LAND || typed.Op == token.LOR {
+			operands = p.conditionOperands(typed.X, operands)
+			return p.conditionOperands(typed.Y, operands)
 		}
+	case *ast.ParenExpr:
+		return p.conditionOperands(typed.X, operands)
+	case *ast.UnaryExpr:
+		if typed.Op == token.NOT {
+			return p.conditionOperands(typed.X, operands)
+		}
+	}
+	return append(operands, frame.Operand{
+		Expr:   p.text(expr),
+		Offset: p.position(expr.Pos()).Offset,
+		End:    p.position(expr.End()).Offset,
+	})
+}
+
+func (p *Parser) parseFuncLit(funcLit *ast.FuncLit, name string) {
+	funcFrame := frame.NewFuncFrame(p.frameCtx.GetInnerName(name))
+	if funcLit.Type.Results != nil {
+		funcFrame.MarkResult()
 	}
+	p.parseBlock(funcLit.Body, name, funcLit.Pos(), funcFrame)
 }
 
-func (p *Parser) parseBlock(body *ast.BlockStmt, blockName string, startLine int, blockFrame frame.Frame) bool {
+func (p *Parser) parseBlock(body *ast.BlockStmt, blockName string, start token.Pos, blockFrame frame.Frame) bool {
 	p.level++
 	defer func() { p.level-- }()
 
 	stmts := body.List
 
-	blockFrame.SetPosLine(startLine, p.getLine(body.Lbrace), p.getLine(body.Rbrace))
+	blockFrame.SetPos(p.position(start), p.position(body.Lbrace+1), p.position(body.Rbrace))
 	p.frameCtx.Push(blockFrame)
 	defer p.frameCtx.Pop()
 
@@ -0,0 +1,833 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Unixeno/gootprint/frame"
+	"github.com/Unixeno/gootprint/trace"
+)
+
+// instrumentSource generates the instrumented source of a file with default options
+func instrumentSource(t *testing.T, source string) []byte {
+	return instrumentWith(t, source, frame.Options{}, false)
+}
+
+// instrumentWith generates the instrumented source of a file, the file is type checked if typed is set
+func instrumentWith(t *testing.T, source string, options frame.Options, typed bool) []byte {
+	t.Helper()
+	content, _ := generate(t, source, options, typed)
+	return content
+}
+
+// instrumentFrames generates the instrumented source of a file with default options and checks it compiles,
+// it returns kinds of frames by their paths without positions, such as `main.main_1.anonymous-func_1`
+func instrumentFrames(t *testing.T, source string) map[string]string {
+	t.Helper()
+	content, manifest := generate(t, source, frame.Options{}, false)
+	typeCheck(t, content)
+	frames := map[string]string{}
+	for _, point := range manifest.Points {
+		frames[point.Path[strings.Index(point.Path, "}")+1:]] = point.Kind
+	}
+	return frames
+}
+
+// checkFrames reports frames not instrumented, or instrumented with another kind
+func checkFrames(t *testing.T, frames, want map[string]string) {
+	t.Helper()
+	for path, kind := range want {
+		if frames[path] != kind {
+			t.Errorf("frame %s is %q, want %q, frames: %v", path, frames[path], kind, frames)
+		}
+	}
+}
+
+// generate instruments a file, it returns the instrumented source and the manifest of its points
+func generate(t *testing.T, source string, options frame.Options, typed bool) ([]byte, *frame.Manifest) {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "main.go")
+	if err := os.WriteFile(filename, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var p *Parser
+	if typed {
+		pkg := newTypeChecker().Package(filename)
+		if pkg == nil {
+			t.Fatal("failed to type check source")
+		}
+		p = NewTypedParser(filename, "main.go", options, pkg)
+	} else {
+		p = NewParser(filename, "main.go", options)
+	}
+	p.Parse()
+	context := p.FrameContext()
+	context.PrepareGenerate()
+	content := context.Generate(p.source)
+	content = append(append(content, '\n'), context.GenerateEnv()...)
+	return content, context.Manifest()
+}
+
+// typeCheck reports errors of the instrumented source, the sdk is imported from the module
+func typeCheck(t *testing.T, content []byte) {
+	t.Helper()
+	fSet := token.NewFileSet()
+	f, err := parser.ParseFile(fSet, "main.gen.go", content, 0)
+	if err != nil {
+		t.Fatalf("failed to parse instrumented source: %v\n%s", err, content)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fSet, "source", nil)}
+	if _, err = conf.Check("main", fSet, []*ast.File{f}, nil); err != nil {
+		t.Fatalf("instrumented source doesn't compile: %v\n%s", err, content)
+	}
+}
+
+// setGoVersion sets the language version of the module during a test
+func setGoVersion(t *testing.T, version string) {
+	previous := GoVersion
+	GoVersion = version
+	t.Cleanup(func() {
+		GoVersion = previous
+	})
+}
+
+// execInstrumented runs the instrumented source as the main package of a module,
+// it returns the output of the program and the name of its trace file
+func execInstrumented(t *testing.T, goVersion string, content []byte) ([]byte, string, error) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("builds and runs the instrumented source")
+	}
+	dir := t.TempDir()
+	module := writeSampleModule(t, dir, goVersion, map[string]string{"main.go": string(content)})
+	build := exec.Command("go", "build", "-o", "sample", ".")
+	build.Dir = module
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build instrumented source: %v\n%s\n%s", err, out, content)
+	}
+	output := filepath.Join(dir, "sample.trace")
+	cmd := exec.Command(filepath.Join(module, "sample"))
+	cmd.Env = append(os.Environ(), "GOOTPRINT_OUTPUT="+output)
+	out, err := cmd.CombinedOutput()
+	return out, output, err
+}
+
+// runInstrumented runs the instrumented source which must succeed, and reads its trace
+func runInstrumented(t *testing.T, goVersion string, content []byte) (*trace.Reader, []trace.Event) {
+	t.Helper()
+	out, output, err := execInstrumented(t, goVersion, content)
+	if err != nil {
+		t.Fatalf("failed to run instrumented source: %v\n%s\n%s", err, out, content)
+	}
+	return readTrace(t, output)
+}
+
+func TestTypeSwitchWithoutCases(t *testing.T) {
+	content := instrumentSource(t, `package main
+
+func main() {
+	var x interface{} = 1
+	switch x.(type) {
+	}
+	switch x.(type) {
+	case int:
+	}
+}
+`)
+	typeCheck(t, content)
+}
+
+// a select without cases blocks forever, the time it starts is not recorded
+func TestSelectWithoutCases(t *testing.T) {
+	content := instrumentSource(t, `package main
+
+func main() {
+	ch := make(chan int)
+	select {
+	case <-ch:
+	}
+	select {}
+}
+`)
+	typeCheck(t, content)
+}
+
+// untyped constants and shifts keep the type of parameters when arguments of go statements are bound
+func TestGoArgsOfUntypedValues(t *testing.T) {
+	source := `package main
+
+import "math"
+
+type level uint8
+
+func f32(float32)   {}
+func u64(uint64)    {}
+func shift(uint64)  {}
+func set(level)     {}
+func many(...int64) {}
+
+func main() {
+	n := 3
+	x := float32(1)
+	go f32(math.Pi)
+	go u64(math.MaxUint64)
+	go shift(1 << n)
+	go f32(x + math.Pi)
+	go set(level(n) + 1)
+	go many(1<<n, int64(n))
+}
+`
+	for _, typed := range []bool{false, true} {
+		typeCheck(t, instrumentWith(t, source, frame.Options{}, typed))
+	}
+}
+
+const iteratorSample = `package main
+
+import "iter"
+
+type list []int
+
+func (l list) All() iter.Seq[int] {
+	return func(yield func(int) bool) {
+		for _, v := range l {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+func count(n int) func(func(int) bool) {
+	return func(yield func(int) bool) {
+		for i := 0; i < n; i++ {
+			if !yield(i) {
+				return
+			}
+		}
+	}
+}
+
+func first(seq iter.Seq[int]) int {
+	for v := range seq {
+		if v > 1 {
+			return v
+		}
+	}
+	return -1
+}
+
+func main() {
+	for i := range count(10) {
+		if i == 2 {
+			break
+		}
+	}
+	l := list{1, 2, 3}
+	for v := range l.All() {
+		if v == 1 {
+			break
+		}
+	}
+	first(count(5))
+}
+`
+
+// loops over function iterators left by an early break or return are recorded once after the iterator returns,
+// and method iterators are recognized without type information
+func TestRangeFuncBreak(t *testing.T) {
+	setGoVersion(t, "1.23")
+	for _, typed := range []bool{false, true} {
+		content := instrumentWith(t, iteratorSample, frame.Options{}, typed)
+		typeCheck(t, content)
+		if n := bytes.Count(content, []byte(frame.SDKPackagePrefix+"Goroutine()")); n != 3 {
+			t.Errorf("%d loops are instrumented as function iterators, want 3, typed: %v\n%s", n, typed, content)
+		}
+	}
+
+	_, events := runInstrumented(t, "1.23", instrumentSource(t, iteratorSample))
+	type exit struct {
+		iterations uint64
+		reason     trace.LoopReason
+	}
+	exits := map[exit]int{}
+	for _, event := range events {
+		if loop, ok := event.(trace.Loop); ok {
+			if loop.Goroutine == 0 {
+				t.Errorf("goroutine of loop %#x is not recorded", loop.Point)
+			}
+			exits[exit{loop.Iterations, loop.Reason}]++
+		}
+	}
+	want := map[exit]int{{3, trace.LoopBreak}: 1, {1, trace.LoopBreak}: 1, {3, trace.LoopReturn}: 1}
+	if !reflect.DeepEqual(exits, want) {
+		t.Errorf("loops are recorded as %v, want %v", exits, want)
+	}
+}
+
+// a build constraint of the file overrides the language version of the go mod file
+func TestRangeFuncFileVersion(t *testing.T) {
+	setGoVersion(t, "1.16")
+	source := "//go:build go1.23\n\n" + iteratorSample
+	content := instrumentSource(t, source)
+	typeCheck(t, content)
+	if !bytes.Contains(content, []byte(frame.SDKPackagePrefix+"Goroutine()")) {
+		t.Errorf("function iterators are not recognized by the version of build constraint\n%s", content)
+	}
+	setGoVersion(t, "1.22")
+	if content := instrumentSource(t, iteratorSample); bytes.Contains(content, []byte(frame.SDKPackagePrefix+"Goroutine()")) {
+		t.Errorf("function iterators are recognized before go 1.23\n%s", content)
+	}
+}
+
+// terminatingCases are functions end with or contain terminating statements, instrumented code must not follow them
+var terminatingCases = []struct {
+	name   string
+	source string
+}{
+	{"goto", `func gotoLoop(n int) int {
+	i := 0
+loop:
+	if i < n {
+		i++
+		goto loop
+	}
+	return i
+}
+
+func gotoEnd(n int) int {
+	if n > 0 {
+		goto done
+	}
+	n = -n
+done:
+	return n
+}`},
+	{"labeled break and continue", `func labeled(rows [][]int) int {
+	sum := 0
+outer:
+	for _, row := range rows {
+		for _, v := range row {
+			if v < 0 {
+				continue outer
+			}
+			if v == 0 {
+				break outer
+			}
+			sum += v
+		}
+	}
+	return sum
+}`},
+	{"fallthrough", `func fall(n int) int {
+	switch n {
+	case 0:
+		n++
+		fallthrough
+	case 1:
+		return n
+	default:
+		n--
+	}
+	return n
+}`},
+	{"panic", `func mustPositive(n int) int {
+	if n < 0 {
+		panic("negative")
+	}
+	return n
+}
+
+func fail() int {
+	panic("fail")
+}`},
+	{"os.Exit", `func exit(code int) int {
+	if code != 0 {
+		os.Exit(code)
+	}
+	return 0
+}`},
+	{"log.Fatal", `func fatal(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case err.Error() == "":
+		log.Fatal(err)
+	case len(err.Error()) > 10:
+		log.Fatalln(err)
+	}
+	log.Fatalf("failed: %v", err)
+	return 1
+}`},
+	{"endless", `func forever() int {
+	for {
+	}
+}
+
+func block() int {
+	select {}
+}`},
+}
+
+// noReturnCall matches code injected after a call never returns
+var noReturnCall = regexp.MustCompile(`(os\.Exit|log\.Fatal\w*|panic)\(.*\)\s*;\s*` + regexp.QuoteMeta(frame.SDKPackagePrefix))
+
+// instrumented code of terminating statements compiles, and passes go vet without unreachable code
+func TestTerminatingStatements(t *testing.T) {
+	files := map[string]string{"main.go": "package main\n\nfunc main() {}\n"}
+	for i, c := range terminatingCases {
+		source := "package main\n\nimport (\n\t\"log\"\n\t\"os\"\n)\n\nvar _, _ = log.Fatal, os.Exit\n\n" + c.source + "\n"
+		content := instrumentSource(t, source)
+		typeCheck(t, content)
+		if noReturnCall.Match(content) {
+			t.Errorf("%s: code is injected after a call never returns\n%s", c.name, content)
+		}
+		files[fmt.Sprintf("case%d.go", i)] = string(content)
+	}
+	if testing.Short() {
+		t.Skip("runs go vet")
+	}
+	module := writeSampleModule(t, t.TempDir(), "1.16", files)
+	cmd := exec.Command("go", "vet", ".")
+	cmd.Dir = module
+	if out, err := cmd.CombinedOutput(); err != nil || bytes.Contains(out, []byte("unreachable code")) {
+		t.Errorf("go vet fails on instrumented code: %v\n%s", err, out)
+	}
+}
+
+const loopSample = `package main
+
+func skipEven(n int) int {
+	sum := 0
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			continue
+		}
+		sum += i
+	}
+	return sum
+}
+
+func breakAt(n int) {
+	for i := 0; ; i++ {
+		if i == n {
+			break
+		}
+	}
+}
+
+func negative(xs []int) int {
+	for i, x := range xs {
+		if x < 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+func continueOuter() {
+outer:
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if j == 1 {
+				continue outer
+			}
+		}
+	}
+}
+
+func breakOuter() {
+outer:
+	for {
+		for j := 0; ; j++ {
+			if j == 2 {
+				break outer
+			}
+		}
+	}
+}
+
+func gotoDone() {
+	for i := 0; i < 10; i++ {
+		if i == 4 {
+			goto done
+		}
+	}
+done:
+}
+
+func main() {
+	skipEven(5)
+	breakAt(3)
+	negative([]int{1, 2, -1, 4})
+	continueOuter()
+	breakOuter()
+	gotoDone()
+}
+`
+
+// in loop mode, a loop is recorded once with its iterations and how it finishes
+func TestLoopCount(t *testing.T) {
+	content := instrumentWith(t, loopSample, frame.Options{LoopCount: true}, false)
+	typeCheck(t, content)
+	reader, events := runInstrumented(t, "1.16", content)
+
+	type exit struct {
+		iterations uint64
+		reason     trace.LoopReason
+	}
+	exits := map[string]map[exit]int{} // function => exits of its loops
+	for _, event := range events {
+		loop, ok := event.(trace.Loop)
+		if !ok {
+			continue
+		}
+		point, ok := reader.Point(loop.Point)
+		if !ok {
+			t.Fatalf("point %#x of loop is not registered", loop.Point)
+		}
+		// function of the loop, such as skipEven of `{5[5:11]11}main.skipEven_1.for_1`
+		function := strings.SplitN(point.Path[strings.Index(point.Path, "}")+1:], ".", 3)[1]
+		function = function[:strings.LastIndex(function, "_")]
+		if exits[function] == nil {
+			exits[function] = map[exit]int{}
+		}
+		exits[function][exit{loop.Iterations, loop.Reason}]++
+	}
+	want := map[string]map[exit]int{
+		"skipEven":      {{5, trace.LoopCondition}: 1},
+		"breakAt":       {{4, trace.LoopBreak}: 1},
+		"negative":      {{3, trace.LoopReturn}: 1},
+		"continueOuter": {{2, trace.LoopJump}: 3, {3, trace.LoopCondition}: 1},
+		"breakOuter":    {{3, trace.LoopJump}: 1, {1, trace.LoopBreak}: 1},
+		"gotoDone":      {{5, trace.LoopJump}: 1},
+	}
+	if !reflect.DeepEqual(exits, want) {
+		t.Errorf("loops are recorded as %v, want %v", exits, want)
+	}
+}
+
+const panicSample = `package main
+
+func a() { b() }
+
+func b() { c() }
+
+func c() { panic("boom") }
+
+func main() { a() }
+`
+
+// a panic is captured once by the innermost function, outer functions record their exits as panicked without
+// recovering, so the stack trace only has one more frame of the re-panic
+func TestExitDeferPanic(t *testing.T) {
+	content := instrumentWith(t, panicSample, frame.Options{ExitDefer: true}, false)
+	out, output, err := execInstrumented(t, "1.16", content)
+	if err == nil {
+		t.Fatalf("panic doesn't crash the program\n%s", out)
+	}
+	if n := bytes.Count(out, []byte("\npanic(")); n != 1 {
+		t.Errorf("stack trace has %d frames of panic, want 1\n%s", n, out)
+	}
+	_, events := readTrace(t, output)
+	panics, panicked := 0, 0
+	for _, event := range events {
+		switch typed := event.(type) {
+		case trace.Panic:
+			panics++
+			if typed.Message != "boom" {
+				t.Errorf("panic is captured as %q", typed.Message)
+			}
+		case trace.Exit:
+			if typed.Panicked {
+				panicked++
+			}
+		}
+	}
+	if panics != 1 || panicked != 4 {
+		t.Errorf("%d panics and %d panicked exits are recorded, want 1 and 4", panics, panicked)
+	}
+}
+
+// function literals passed as arguments are instrumented at any depth of calls
+func TestFuncLitArgs(t *testing.T) {
+	frames := instrumentFrames(t, `package main
+
+import "sort"
+
+func apply(f func(int) int, x int) int { return f(x) }
+
+func wrap(v ...interface{}) {}
+
+func main() {
+	xs := []int{3, 1, 2}
+	sort.Slice(xs, func(i, j int) bool { return xs[i] < xs[j] })
+	wrap(apply(func(x int) int { return x + 1 }, apply(func(x int) int { return x * 2 }, 1)))
+	_ = apply(func(x int) int {
+		return apply(func(y int) int { return y }, x)
+	}, 1)
+}
+`)
+	checkFrames(t, frames, map[string]string{
+		"main.main_3.anonymous-call-arg_1":                               frame.KindFunc,
+		"main.main_3.anonymous-call-args-arg_2":                          frame.KindFunc,
+		"main.main_3.anonymous-call-args-args-arg_3":                     frame.KindFunc,
+		"main.main_3.anonymous-assign-call-arg_4":                        frame.KindFunc,
+		"main.main_3.anonymous-assign-call-arg_4.anonymous-return-arg_1": frame.KindFunc,
+	})
+}
+
+// goroutines started by go statements of any target are bound to the function starting them
+func TestGoTargets(t *testing.T) {
+	source := `package main
+
+import "strings"
+
+type server struct {
+	handlers map[string]func(string)
+}
+
+func (s *server) worker(n int) {}
+
+func main() {
+	s := &server{handlers: map[string]func(string){}}
+	fns := []func(){func() {}}
+	fn := func(n int) {}
+	go s.worker(1)
+	go strings.Repeat("x", 2)
+	go s.handlers["a"]("req")
+	go fns[0]()
+	go fn(3)
+	go func() {}()
+}
+`
+	parent := regexp.MustCompile(`func main\(\) \{var (\w+) = `)
+	for _, typed := range []bool{false, true} {
+		content := instrumentWith(t, source, frame.Options{}, typed)
+		typeCheck(t, content)
+		match := parent.FindSubmatch(content)
+		if match == nil {
+			t.Fatalf("main is not instrumented\n%s", content)
+		}
+		if n := bytes.Count(content, []byte(frame.SDKPackagePrefix+"Bind("+string(match[1])+")")); n != 6 {
+			t.Errorf("%d of 6 goroutines are bound to main, typed: %v\n%s", n, typed, content)
+		}
+	}
+}
+
+// methods of generic types are instrumented with type parameters of their receivers in frame paths
+func TestGenericReceivers(t *testing.T) {
+	frames := instrumentFrames(t, `package main
+
+type List[T any] struct{ items []T }
+
+func (l *List[T]) Push(v T) { l.items = append(l.items, v) }
+
+func (l List[T]) Len() int { return len(l.items) }
+
+type Pair[K comparable, V any] struct {
+	key K
+	val V
+}
+
+func (p *Pair[K, V]) Set(k K, v V) { p.key, p.val = k, v }
+
+func (p Pair[K, _]) Key() K { return p.key }
+
+func Map[T, U any](xs []T, f func(T) U) []U { return nil }
+
+func main() {}
+`)
+	checkFrames(t, frames, map[string]string{
+		"main.*List[T]_Push_1":  frame.KindFunc,
+		"main.List[T]_Len_2":    frame.KindFunc,
+		"main.*Pair[K,V]_Set_3": frame.KindFunc,
+		"main.Pair[K,_]_Key_4":  frame.KindFunc,
+		"main.Map[T,U]_5":       frame.KindFunc,
+	})
+}
+
+const deferSample = `package main
+
+import "fmt"
+
+func cleanup(name string) {}
+
+func run() (err error) {
+	defer cleanup("first")
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	defer func(n int) {
+		defer func() {}()
+	}(1)
+	panic("boom")
+}
+
+func main() {
+	run()
+}
+`
+
+// deferred closures get frames of their own, defers are recorded when they are registered and when they run
+func TestDeferredClosures(t *testing.T) {
+	checkFrames(t, instrumentFrames(t, deferSample), map[string]string{
+		"main.run_2.defer-cleanup_1":                     frame.KindDefer,
+		"main.run_2.defer-anonymous_2":                   frame.KindDefer,
+		"main.run_2.defer-anonymous_2.recover_1":         frame.KindRecover,
+		"main.run_2.defer-anonymous_3":                   frame.KindDefer,
+		"main.run_2.defer-anonymous_3.defer-anonymous_1": frame.KindDefer,
+	})
+
+	reader, events := runInstrumented(t, "1.16", instrumentSource(t, deferSample))
+	var registered, ran []string
+	path := func(id uint64) string {
+		point, ok := reader.Point(id)
+		if !ok {
+			t.Fatalf("point %#x is not registered", id)
+		}
+		return point.Path[strings.Index(point.Path, "}")+1:]
+	}
+	for _, event := range events {
+		switch typed := event.(type) {
+		case trace.Defer:
+			registered = append(registered, path(typed.Point))
+		case trace.Call:
+			if name := path(typed.Point); strings.Contains(name, ".defer-") {
+				ran = append(ran, name)
+			}
+		}
+	}
+	wantRegistered := []string{
+		"main.run_2.defer-cleanup_1",
+		"main.run_2.defer-anonymous_2",
+		"main.run_2.defer-anonymous_3",
+		"main.run_2.defer-anonymous_3.defer-anonymous_1",
+	}
+	wantRan := []string{
+		"main.run_2.defer-anonymous_3",
+		"main.run_2.defer-anonymous_3.defer-anonymous_1",
+		"main.run_2.defer-anonymous_2",
+		"main.run_2.defer-cleanup_1",
+	}
+	if !reflect.DeepEqual(registered, wantRegistered) {
+		t.Errorf("defers are registered as %v, want %v", registered, wantRegistered)
+	}
+	if !reflect.DeepEqual(ran, wantRan) {
+		t.Errorf("defers run as %v, want %v", ran, wantRan)
+	}
+}
+
+// function literals in composite literals, returns, channel sends and init statements are instrumented
+func TestFuncLitsInExpressions(t *testing.T) {
+	frames := instrumentFrames(t, `package main
+
+type server struct {
+	Handler func(string) int
+}
+
+func handlers() map[string]func() {
+	return map[string]func(){
+		"a": func() {},
+		"b": func() {},
+	}
+}
+
+func counter() func() int {
+	n := 0
+	return func() int {
+		n++
+		return n
+	}
+}
+
+func main() {
+	s := server{Handler: func(string) int { return 1 }}
+	list := []func(){func() {}}
+	ch := make(chan func(), 1)
+	ch <- func() {}
+	if f := func() bool { return true }; f() {
+	}
+	for f := func() int { return 0 }; f() > 0; {
+	}
+	switch f := func() int { return 1 }; f() {
+	}
+	_, _ = s, list
+}
+`)
+	checkFrames(t, frames, map[string]string{
+		"main.handlers_1.anonymous-return_1": frame.KindFunc,
+		"main.handlers_1.anonymous-return_2": frame.KindFunc,
+		"main.counter_2.anonymous-return_1":  frame.KindFunc,
+		"main.main_3.anonymous-assign_1":     frame.KindFunc, // struct field
+		"main.main_3.anonymous-assign_2":     frame.KindFunc, // slice element
+		"main.main_3.anonymous-send_3":       frame.KindFunc,
+		"main.main_3.anonymous-assign_4":     frame.KindFunc, // if init
+		"main.main_3.anonymous-assign_6":     frame.KindFunc, // for init
+		"main.main_3.anonymous-assign_8":     frame.KindFunc, // switch init
+	})
+}
+
+const initSample = `package main
+
+func build(f func() int) int { return f() }
+
+var handler = func(s string) int { return len(s) }
+
+var defaultX = build(func() int { return 1 })
+
+var (
+	a, b = func() {}, func() {}
+)
+
+func init() {
+	handler("x")
+}
+
+func init() {
+	a()
+}
+
+func main() {}
+`
+
+// function literals of package level variables are instrumented, init functions are recorded in order
+func TestPackageInitializers(t *testing.T) {
+	checkFrames(t, instrumentFrames(t, initSample), map[string]string{
+		"main.anonymous-var_2":          frame.KindFunc,
+		"main.anonymous-var-call-arg_3": frame.KindFunc,
+		"main.anonymous-var_4":          frame.KindFunc,
+		"main.anonymous-var_5":          frame.KindFunc,
+		"main.init_6":                   frame.KindFunc,
+		"main.init_7":                   frame.KindFunc,
+	})
+
+	reader, events := runInstrumented(t, "1.16", instrumentSource(t, initSample))
+	var inits []string
+	for _, event := range events {
+		if init, ok := event.(trace.Init); ok {
+			point, ok := reader.Point(init.Point)
+			if !ok {
+				t.Fatalf("point %#x of init is not registered", init.Point)
+			}
+			inits = append(inits, point.Path[strings.Index(point.Path, "}")+1:])
+		}
+	}
+	if want := []string{"main.init_6", "main.init_7"}; !reflect.DeepEqual(inits, want) {
+		t.Errorf("init functions are recorded as %v, want %v", inits, want)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
+	"github.com/Unixeno/gootprint/frame"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -16,9 +18,12 @@ var dryRun = flag.Bool("dry", false, "dry run, only parse file, not generate cod
 var outputStdout = flag.Bool("print", false, "output to stdout instead of a file, will set -no-rename by default")
 var noRename = flag.Bool("no-rename", false, "do not replace source file after generate")
 var stats = flag.Bool("stat", false, "show source code statistics")
+var overlayDir = flag.String("overlay", "", "write instrumented files into `directory` and generate an overlay file for `go build -overlay`, source files are untouched")
 var clean = flag.Bool("clean", false, "delete generated files and rename source file back")
-var verbose = flag.Bool("v", false, "verbose mode, show debug log")
-var silence = flag.Bool("s", false, "silence mode, hide info log")
+var remapFrom = flag.String("remap-from", "", "with -stable-paths, compare frame paths with manifests of an earlier generation in `directory` or manifest file, and write remap files of paths changed")
+var instrument = registerInstrumentFlags(flag.CommandLine)
+var verbose bool
+var silence bool
 var excludeList []string
 var targetPath string
 
@@ -31,13 +36,55 @@ func validateDir(dir string) bool {
 	return fileInfo.IsDir()
 }
 
-func validate() {
-	if *silence {
+// instrumentFlags are flags of instrumentation options, they are registered by the generator,
+// and by subcommands instrumenting source code with their own flag sets
+type instrumentFlags struct {
+	exitDefer         *bool
+	branchCoverage    *bool
+	loopCount         *bool
+	stablePaths       *bool
+	typeCheck         *bool
+	conditionCoverage *bool
+}
+
+func registerInstrumentFlags(flags *flag.FlagSet) *instrumentFlags {
+	return &instrumentFlags{
+		exitDefer:         flags.Bool("exit-defer", false, "record function exit by an injected defer, covers early returns and panics"),
+		branchCoverage:    flags.Bool("branch", false, "record branches not written in source, the else of if and default of switch"),
+		loopCount:         flags.Bool("loop", false, "record a loop once it finishes with the iteration count and the exit reason, instead of every iteration"),
+		stablePaths:       flags.Bool("stable-paths", false, "name frames by qualified function name and source fingerprint, so paths survive unrelated edits"),
+		typeCheck:         flags.Bool("types", false, "parse and type check files with their packages, instrument with resolved types instead of syntax"),
+		conditionCoverage: flags.Bool("condition", false, "record every operand of && and || in conditions of if, for and tagless switch"),
+	}
+}
+
+// options collects options for code generation from flags
+func (f *instrumentFlags) options() frame.Options {
+	return frame.Options{
+		ExitDefer:         *f.exitDefer,
+		BranchCoverage:    *f.branchCoverage,
+		ConditionCoverage: *f.conditionCoverage,
+		LoopCount:         *f.loopCount,
+		StablePaths:       *f.stablePaths,
+	}
+}
+
+func registerLogFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&verbose, "v", false, "verbose mode, show debug log")
+	flags.BoolVar(&silence, "s", false, "silence mode, hide info log")
+}
+
+func setLogLevel() {
+	if silence {
 		log.SetLevel(log.WarnLevel)
 	}
-	if *verbose {
+	if verbose {
 		log.SetLevel(log.DebugLevel)
 	}
+}
+
+func validate() {
+	setLogLevel()
 	if *file == "" && *packageDir == "" && *dir == "" {
 		log.Fatal("need a valid filename, directory or package to process")
 	}
@@ -45,6 +92,17 @@ func validate() {
 		log.Fatalf("path `%s` is not a directory", *dir)
 	}
 
+	// module is optional for file and directory, it's used to get stable file names
+	if *file != "" {
+		if absFile, err := filepath.Abs(*file); err == nil {
+			LocateModule(filepath.Dir(absFile))
+		}
+	} else if *dir != "" {
+		if absDir, err := filepath.Abs(*dir); err == nil {
+			LocateModule(absDir)
+		}
+	}
+
 	if *packageDir != "" {
 		if validateDir(*packageDir) { // package in directory form
 			if !LocateModule(*packageDir) {
@@ -56,15 +114,26 @@ func validate() {
 	}
 }
 
-func main() {
-	flag.Func("e", "short version of '-exclude'", func(s string) error {
+func registerExcludeFlags(flags *flag.FlagSet) {
+	flags.Func("e", "short version of '-exclude'", func(s string) error {
 		excludeList = append(excludeList, s)
 		return nil
 	})
-	flag.Func("exclude", "specify exclude `directory`, only works in package mode", func(s string) error {
+	flags.Func("exclude", "specify exclude `directory`, only works in package mode", func(s string) error {
 		excludeList = append(excludeList, s)
 		return nil
 	})
+}
+
+func main() {
+	if len(os.Args) > 1 && goSubcommands[os.Args[1]] {
+		os.Exit(runGoSubcommand(os.Args[1], os.Args[2:]))
+	}
+	if len(os.Args) > 1 && traceSubcommands[os.Args[1]] != nil {
+		os.Exit(traceSubcommands[os.Args[1]](os.Args[2:]))
+	}
+	registerLogFlags(flag.CommandLine)
+	registerExcludeFlags(flag.CommandLine)
 	flag.Parse()
 	validate()
 
@@ -81,6 +150,15 @@ func main() {
 		targetPath = *packageDir
 	}
 
+	processor.SetOptions(instrument.options())
+	processor.SetTypeCheck(*instrument.typeCheck)
+	if *overlayDir != "" {
+		processor.SetOverlay(*overlayDir)
+	}
+	if *remapFrom != "" {
+		processor.SetRemapFrom(*remapFrom)
+	}
+
 	if *clean {
 		processor.ProcessClean()
 		return
@@ -98,6 +176,9 @@ func main() {
 		fmt.Printf(" >> if amount: \t\t%d\n", codeStats.IfAmount)
 		fmt.Printf(" >> for amount: \t%d\n", codeStats.ForAmount)
 		fmt.Printf(" >> case amount: \t%d\n", codeStats.CaseAmount)
+		fmt.Printf(" >> recover amount: \t%d\n", codeStats.RecoverAmount)
+		fmt.Printf(" >> defer amount: \t%d\n", codeStats.DeferAmount)
+		fmt.Printf(" >> condition amount: \t%d\n", codeStats.CondAmount)
 		fmt.Printf("source file has %d lines, will produce %d tracing point(%.2f%%)\n",
 			codeStats.Lines,
 			codeStats.InjectionPoint,
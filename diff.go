@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// fileDiff is the change of a file in a unified diff, names are kept as written, such as `a/main.go`
+type fileDiff struct {
+	oldName string
+	newName string
+	hunks   []hunk
+}
+
+// hunk is a changed range of a file, lines kept in the hunk are mapped from old to new
+type hunk struct {
+	oldStart int
+	oldLines int
+	newLines int
+	kept     map[int]int
+}
+
+// Line maps a line of the old file to the new file, it's false if the line is removed or changed
+func (d *fileDiff) Line(old int) (int, bool) {
+	delta := 0
+	for _, h := range d.hunks {
+		if old < h.oldStart {
+			break
+		}
+		if old < h.oldStart+h.oldLines {
+			line, ok := h.kept[old]
+			return line, ok
+		}
+		delta += h.newLines - h.oldLines
+	}
+	return old + delta, true
+}
+
+// sameFile reports whether a name in diff refers to a file key, names in diff may have a prefix,
+// such as `a/` of git, or the path from repository root to module root
+func sameFile(name, key string) bool {
+	return name == key || strings.HasSuffix(name, "/"+key)
+}
+
+// parseDiff reads files changed in a unified diff, such as the output of `git diff` or `diff -u`
+func parseDiff(r io.Reader) ([]*fileDiff, error) {
+	var files []*fileDiff
+	var current *fileDiff
+	var h *hunk
+	var oldLine, newLine, oldLeft, newLeft int
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for number := 1; scanner.Scan(); number++ {
+		line := scanner.Text()
+		if h != nil && (oldLeft > 0 || newLeft > 0) {
+			switch {
+			case strings.HasPrefix(line, " ") || line == "":
+				h.kept[oldLine] = newLine
+				oldLine, newLine, oldLeft, newLeft = oldLine+1, newLine+1, oldLeft-1, newLeft-1
+			case strings.HasPrefix(line, "-"):
+				oldLine, oldLeft = oldLine+1, oldLeft-1
+			case strings.HasPrefix(line, "+"):
+				newLine, newLeft = newLine+1, newLeft-1
+			case strings.HasPrefix(line, `\`): // no newline at end of file
+			default:
+				return nil, fmt.Errorf("line %d: unexpected line in hunk", number)
+			}
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "--- "):
+			current = &fileDiff{oldName: diffName(line[4:])}
+			files = append(files, current)
+			h = nil
+		case strings.HasPrefix(line, "+++ ") && current != nil:
+			current.newName = diffName(line[4:])
+		case strings.HasPrefix(line, "@@ ") && current != nil:
+			oldStart, oldLines, newStart, newLines, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", number, err)
+			}
+			h = &hunk{oldStart: oldStart, oldLines: oldLines, newLines: newLines, kept: map[int]int{}}
+			if oldLines == 0 { // lines are added after oldStart
+				h.oldStart++
+			}
+			current.hunks = append(current.hunks, *h)
+			h = &current.hunks[len(current.hunks)-1]
+			oldLine, newLine, oldLeft, newLeft = h.oldStart, newStart, oldLines, newLines
+		}
+	}
+	return files, scanner.Err()
+}
+
+// diffName removes the timestamp written by `diff -u`
+func diffName(name string) string {
+	if index := strings.IndexByte(name, '\t'); index >= 0 {
+		name = name[:index]
+	}
+	return strings.TrimSpace(name)
+}
+
+// parseHunkHeader parses `@@ -oldStart,oldLines +newStart,newLines @@`, lines are 1 if omitted
+func parseHunkHeader(line string) (oldStart, oldLines, newStart, newLines int, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return 0, 0, 0, 0, fmt.Errorf("bad hunk header: %s", line)
+	}
+	if oldStart, oldLines, err = parseRange(fields[1][1:]); err != nil {
+		return 0, 0, 0, 0, err
+	}
+	if newStart, newLines, err = parseRange(fields[2][1:]); err != nil {
+		return 0, 0, 0, 0, err
+	}
+	return oldStart, oldLines, newStart, newLines, nil
+}
+
+func parseRange(s string) (start, lines int, err error) {
+	lines = 1
+	if index := strings.IndexByte(s, ','); index >= 0 {
+		if lines, err = strconv.Atoi(s[index+1:]); err != nil {
+			return 0, 0, fmt.Errorf("bad hunk range: %s", s)
+		}
+		s = s[:index]
+	}
+	if start, err = strconv.Atoi(s); err != nil {
+		return 0, 0, fmt.Errorf("bad hunk range: %s", s)
+	}
+	return start, lines, nil
+}
@@ -2,19 +2,24 @@ package frame
 
 import (
 	"fmt"
+	"go/token"
 
 	log "github.com/sirupsen/logrus"
 )
 
 type baseFrame struct {
-	headBegin   int     // line number of the block beginning, is the position of first token in the block
-	bodyBegin   int     // line number of {
-	bodyEnd     int     // line number of }, or the return statement
-	blockEnd    int     // the block end line,
-	path        string  // frame path, is the unique name of a frame
-	InnerFrame  []Frame // child block in current block
-	isReturn    bool    // whether this block contains an explicit return statement
-	unreachable bool    // block ending is unreachable
+	headBegin       int     // line number of the block beginning, is the position of first token in the block
+	bodyBegin       int     // line number of {
+	bodyEnd         int     // line number of }, or the return statement
+	blockEnd        int     // the block end line,
+	headBeginOffset int     // byte offset of the block beginning
+	bodyBeginOffset int     // byte offset right after { or :, where code is injected at beginning
+	bodyEndOffset   int     // byte offset of }, or the return statement, where code is injected at ending
+	blockEndOffset  int     // byte offset of }, the scope of the block ends here
+	path            string  // frame path, is the unique name of a frame
+	InnerFrame      []Frame // child block in current block
+	isReturn        bool    // whether this block contains an explicit return statement
+	unreachable     bool    // block ending is unreachable
 }
 
 func NewBaseFrame(path string) *baseFrame {
@@ -24,11 +29,15 @@ func NewBaseFrame(path string) *baseFrame {
 	}
 }
 
-func (frame *baseFrame) SetPosLine(headBegin, bodyBegin, bodyEnd int) {
-	frame.headBegin = headBegin
-	frame.bodyBegin = bodyBegin
-	frame.bodyEnd = bodyEnd
-	frame.blockEnd = bodyEnd
+func (frame *baseFrame) SetPos(headBegin, bodyBegin, bodyEnd token.Position) {
+	frame.headBegin = headBegin.Line
+	frame.bodyBegin = bodyBegin.Line
+	frame.bodyEnd = bodyEnd.Line
+	frame.blockEnd = bodyEnd.Line
+	frame.headBeginOffset = headBegin.Offset
+	frame.bodyBeginOffset = bodyBegin.Offset
+	frame.bodyEndOffset = bodyEnd.Offset
+	frame.blockEndOffset = bodyEnd.Offset
 }
 
 func (frame *baseFrame) SetUnreachable() {
@@ -63,8 +72,9 @@ func (frame *baseFrame) BodyEnding() int {
 	return frame.bodyEnd
 }
 
-func (frame *baseFrame) SetReturn(line int) {
-	frame.bodyEnd = line
+func (frame *baseFrame) SetReturn(pos token.Position) {
+	frame.bodyEnd = pos.Line
+	frame.bodyEndOffset = pos.Offset
 	frame.isReturn = true
 }
 
@@ -76,14 +86,22 @@ func (frame *baseFrame) IsReturn() bool {
 	return frame.isReturn
 }
 
-func (frame *baseFrame) GenBeginning(genEnv *baseEnv, content []byte) []byte {
+func (frame *baseFrame) BodyBeginningOffset() int {
+	return frame.bodyBeginOffset
+}
+
+func (frame *baseFrame) BodyEndingOffset() int {
+	return frame.bodyEndOffset
+}
+
+func (frame *baseFrame) GenBeginning(genEnv *baseEnv) []byte {
 	log.Error("implement me: ", frame.path)
-	return content
+	return nil
 }
 
-func (frame *baseFrame) GenEnding(genEnv *baseEnv, content []byte) []byte {
+func (frame *baseFrame) GenEnding(genEnv *baseEnv) []byte {
 	log.Error("implement me: ", frame.path)
-	return content
+	return nil
 }
 
 func (frame *baseFrame) GenEnv(genEnv *baseEnv) []byte {
@@ -91,6 +109,18 @@ func (frame *baseFrame) GenEnv(genEnv *baseEnv) []byte {
 	return []byte{}
 }
 
+func (frame *baseFrame) setPath(path string) {
+	frame.path = path
+}
+
+// source returns the source code of the frame, from the block beginning to the block end
+func (frame *baseFrame) source(content []byte) []byte {
+	if frame.headBeginOffset > frame.blockEndOffset || frame.blockEndOffset > len(content) {
+		return nil
+	}
+	return content[frame.headBeginOffset:frame.blockEndOffset]
+}
+
 func (frame *baseFrame) getStdPath() string {
 	return fmt.Sprintf("{%d[%d:%d]%d}%s", frame.headBegin, frame.bodyBegin, frame.bodyEnd, frame.blockEnd, frame.path)
 }
@@ -5,27 +5,59 @@ import "bytes"
 // CaseFrame switch, select
 type CaseFrame struct {
 	*baseFrame
-	varName string
+	varName    string
+	typeSwitch *TypeSwitchFrame // the type matched is recorded for cases of type switch
+	selectStmt *SelectFrame     // the time blocked is recorded for cases of select
+	captureVar string
 }
 
 func NewCaseFrame(path string) *CaseFrame {
 	return &CaseFrame{baseFrame: NewBaseFrame(path)}
 }
 
-func (frame *CaseFrame) GenBeginning(genEnv *baseEnv, content []byte) []byte {
-	return content
+// MarkTypeSwitch mark a case is in a type switch, the dynamic type matched is recorded when it's chosen
+func (frame *CaseFrame) MarkTypeSwitch(typeSwitch *TypeSwitchFrame) {
+	frame.typeSwitch = typeSwitch
+	typeSwitch.captured = true
 }
 
-func (frame *CaseFrame) GenEnding(genEnv *baseEnv, content []byte) []byte {
+// MarkSelect mark a case is in a select, the time blocked is recorded when it's chosen
+func (frame *CaseFrame) MarkSelect(selectStmt *SelectFrame) {
+	frame.selectStmt = selectStmt
+}
+
+func (frame *CaseFrame) Kind() string {
+	return KindCase
+}
+
+func (frame *CaseFrame) GenBeginning(genEnv *baseEnv) []byte {
+	switch {
+	case frame.typeSwitch != nil:
+		frame.captureVar = genEnv.genPointVarName()
+		return []byte(frame.typeSwitch.genCapture(genEnv, frame.captureVar))
+	case frame.selectStmt != nil:
+		frame.captureVar = genEnv.genPointVarName()
+		return []byte(frame.selectStmt.genCapture(genEnv, frame.captureVar))
+	}
+	return nil
+}
+
+func (frame *CaseFrame) GenEnding(genEnv *baseEnv) []byte {
+	if frame.unreachable {
+		return nil
+	}
 	frame.varName = genEnv.genPointVarName()
-	buf := bytes.NewBuffer(nil)
-	buf.WriteString(genEnv.genCollect(frame.varName))
-	buf.Write(content)
-	return buf.Bytes()
+	return []byte(genEnv.genCollect(frame.varName))
 }
 
 func (frame *CaseFrame) GenEnv(genEnv *baseEnv) []byte {
 	buffer := bytes.NewBuffer(nil)
-	buffer.WriteString(genEnv.genPoint(frame.varName, frame.getStdPath()))
+	switch {
+	case frame.typeSwitch != nil:
+		buffer.WriteString(genEnv.genPoint(frame.captureVar, frame, EventType))
+	case frame.selectStmt != nil:
+		buffer.WriteString(genEnv.genPoint(frame.captureVar, frame, EventSelect))
+	}
+	buffer.WriteString(genEnv.genPoint(frame.varName, frame, EventBlock))
 	return buffer.Bytes()
 }
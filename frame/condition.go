@@ -0,0 +1,87 @@
+package frame
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConditionSeparator separates the decision and the operand in the registered path of a condition point:
+// `{12[12:14]15}main.check_1.cond_2#1 x > 0`
+const ConditionSeparator = "#"
+
+// ConditionFrame is a decision in if, for and tagless switch statements, in condition coverage mode
+// every operand of && and || is recorded with its value, short circuit is kept as operands are wrapped one by one:
+//
+//	a && (b || c) => _g_sdk.Cond(g, e1, bool(a)) && (_g_sdk.Cond(g, e2, bool(b)) || _g_sdk.Cond(g, e3, bool(c)))
+//
+// the conversion makes operands of named bool types acceptable by the sdk
+type ConditionFrame struct {
+	*baseFrame
+	operands []Operand
+	varNames []string
+}
+
+// Operand is a condition which is not combined by && and ||
+type Operand struct {
+	Expr   string // source of the operand
+	Offset int
+	End    int
+}
+
+func NewConditionFrame(path string, operands []Operand) *ConditionFrame {
+	return &ConditionFrame{
+		baseFrame: NewBaseFrame(path),
+		operands:  operands,
+		varNames:  make([]string, len(operands)),
+	}
+}
+
+func (frame *ConditionFrame) Kind() string {
+	return KindCondition
+}
+
+func (frame *ConditionFrame) edits() []edit {
+	edits := make([]edit, 0, 2*len(frame.operands))
+	for i, operand := range frame.operands {
+		i := i
+		edits = append(edits,
+			edit{offset: operand.Offset, end: operand.Offset, gen: func(genEnv *baseEnv) []byte {
+				frame.varNames[i] = genEnv.genPointVarName()
+				return []byte(genEnv.genCondition(frame.varNames[i]))
+			}},
+			edit{offset: operand.End, end: operand.End, gen: func(*baseEnv) []byte {
+				return []byte("))")
+			}},
+		)
+	}
+	return edits
+}
+
+func (frame *ConditionFrame) GenBeginning(genEnv *baseEnv) []byte {
+	return nil
+}
+
+func (frame *ConditionFrame) GenEnding(genEnv *baseEnv) []byte {
+	return nil
+}
+
+func (frame *ConditionFrame) GenEnv(genEnv *baseEnv) []byte {
+	var buf strings.Builder
+	for i, operand := range frame.operands {
+		buf.WriteString(genEnv.genConditionPoint(frame.varNames[i], frame, i+1, operand.Expr))
+	}
+	return []byte(buf.String())
+}
+
+// SplitConditionPath splits the registered path of a condition point into the path of decision and the operand
+func SplitConditionPath(path string) (decision string, operand string, ok bool) {
+	index := strings.Index(path, ConditionSeparator)
+	if index < 0 {
+		return "", "", false
+	}
+	return path[:index], path[index+len(ConditionSeparator):], true
+}
+
+func conditionPath(stdPath string, index int, expr string) string {
+	return fmt.Sprintf("%s%s%d %s", stdPath, ConditionSeparator, index, expr)
+}
@@ -4,3 +4,20 @@ const (
 	VisitPreOrder  = iota
 	VisitPostOrder = iota
 )
+
+// frame kinds
+const (
+	KindPackage    = "package"
+	KindFunc       = "func"
+	KindGoFunc     = "go"
+	KindIfElse     = "if"
+	KindFor        = "for"
+	KindCase       = "case"
+	KindRecover    = "recover"
+	KindDefer      = "defer"
+	KindCondition  = "cond"
+	KindLoop       = "loop"
+	KindRangeFunc  = "range-func"
+	KindTypeSwitch = "type-switch"
+	KindSelect     = "select"
+)
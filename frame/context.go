@@ -3,6 +3,8 @@ package frame
 import (
 	"bytes"
 	"fmt"
+	"go/token"
+	"sort"
 	"strconv"
 
 	log "github.com/sirupsen/logrus"
@@ -11,18 +13,40 @@ import (
 type Context struct {
 	rootFrame Frame // root frame is the package frame
 	indexes   []int // record levels from root frame to current frame，works as a stack
-	hooks     map[int][]func(*baseEnv, []byte) []byte
+	edits     []edit
 	genEnv    *baseEnv
+	options   Options
 }
 
-func NewFrameContext(filename, packageName string, bodyBegin, bodyEnd int) *Context {
+// edit replaces source code between offset and end with generated code, it's an insertion if end equals offset
+type edit struct {
+	offset int
+	end    int
+	gen    func(*baseEnv) []byte
+	first  bool // generated before other edits at the same offset, such as endings of parent frames
+}
+
+// editor is implemented by frames which rewrite source code instead of injecting at beginning and ending
+type editor interface {
+	edits() []edit
+}
+
+// NewFrameContext creates context for a source file, packageBegin is the end of package clause
+func NewFrameContext(filename, fileKey, packageName string, packageBegin, packageEnd token.Position) *Context {
 	return &Context{
-		rootFrame: NewPackageFrame(filename, packageName, bodyBegin, bodyEnd),
+		rootFrame: NewPackageFrame(filename, fileKey, packageName, packageBegin, packageEnd),
 		indexes:   make([]int, 1, 16),
-		hooks:     map[int][]func(*baseEnv, []byte) []byte{},
 	}
 }
 
+func (root *Context) SetOptions(options Options) {
+	root.options = options
+}
+
+func (root *Context) Options() Options {
+	return root.options
+}
+
 func (root *Context) Import(path string) {
 	root.rootFrame.(*PackageFrame).Import(path)
 }
@@ -67,35 +91,48 @@ func (root *Context) PostOrderDump() {
 	log.Debug("============================================")
 }
 
+// PrepareGenerate collects edits from all frames, and sort them by offset,
+// edits at the same offset keep the order of pre-order traversal, unless it's marked first
 func (root *Context) PrepareGenerate() {
 	Visit(root.rootFrame, VisitPreOrder, func(frame Frame) {
-		beginLine := frame.BodyBeginning()
-		endingLine := frame.BodyEnding()
-		if beginLine == endingLine {
-			if _, ok := frame.(*GoFuncFrame); !ok {
-				log.Warnf("block exist in same line, ignore generate, %s", frame)
-				return
-			}
+		if e, ok := frame.(editor); ok {
+			root.edits = append(root.edits, e.edits()...)
+			return
 		}
-
-		root.hooks[frame.BodyBeginning()] = append(root.hooks[frame.BodyBeginning()], frame.GenBeginning)
-		if !frame.Unreachable() {
-			root.hooks[frame.BodyEnding()] = append(root.hooks[frame.BodyEnding()], frame.GenEnding)
-		} else {
-			log.Infof("skip `%s` because it's unreachable", frame.Path())
+		if frame.Unreachable() {
+			log.Infof("`%s` is unreachable, skip ending", frame.Path())
+		}
+		root.edits = append(root.edits,
+			edit{offset: frame.BodyBeginningOffset(), end: frame.BodyBeginningOffset(), gen: frame.GenBeginning},
+			edit{offset: frame.BodyEndingOffset(), end: frame.BodyEndingOffset(), gen: frame.GenEnding},
+		)
+	})
+	sort.SliceStable(root.edits, func(i, j int) bool {
+		if root.edits[i].offset != root.edits[j].offset {
+			return root.edits[i].offset < root.edits[j].offset
 		}
+		return root.edits[i].first && !root.edits[j].first
 	})
 	root.genEnv = root.rootFrame.(*PackageFrame).getEnv()
+	root.genEnv.options = root.options
 	log.Info("prepared")
 }
 
-func (root *Context) GenerateLine(line int, content []byte) []byte {
-	if genFuncs, exist := root.hooks[line]; exist {
-		for _, genFunc := range genFuncs {
-			content = genFunc(root.genEnv, content)
+// Generate applies edits to source code in order
+func (root *Context) Generate(source []byte) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, len(source)*2))
+	last := 0
+	for _, e := range root.edits {
+		if e.offset < last {
+			log.Errorf("overlapped edit at offset %d, ignore generate", e.offset)
+			continue
 		}
+		buf.Write(source[last:e.offset])
+		buf.Write(e.gen(root.genEnv))
+		last = e.end
 	}
-	return content
+	buf.Write(source[last:])
+	return buf.Bytes()
 }
 
 func (root *Context) GenerateEnv() []byte {
@@ -106,6 +143,12 @@ func (root *Context) GenerateEnv() []byte {
 	return buffer.Bytes()
 }
 
+// Manifest returns the manifest of generated points, it's only valid after GenerateEnv
+func (root *Context) Manifest() *Manifest {
+	root.genEnv.manifest.sort()
+	return root.genEnv.manifest
+}
+
 func (root *Context) GetInnerName(suffix string) string {
 	current := root.GetCurrent()
 	name := current.Path() + "." + suffix + "_" + strconv.Itoa(current.Len()+1)
@@ -122,14 +165,26 @@ func (root *Context) Stats() Stats {
 			s.FuncAmount++
 		case *CaseFrame:
 			s.CaseAmount++
-		case *ForFrame:
+		case *ForFrame, *LoopFrame:
 			s.ForAmount++
 		case *GoFuncFrame:
 			s.GoFuncAmount++
+		case *ImplicitFrame:
+			if frame.Kind() == KindIfElse {
+				s.IfAmount++
+			} else {
+				s.CaseAmount++
+			}
+		case *RecoverFrame:
+			s.RecoverAmount++
+		case *DeferFrame:
+			s.DeferAmount++
+		case *ConditionFrame:
+			s.CondAmount += len(frame.(*ConditionFrame).operands)
 		case *PackageFrame:
 			s.Lines = frame.BodyEnding()
 		}
-		s.InjectionPoint = s.IfAmount + s.CaseAmount + s.FuncAmount + s.ForAmount + s.GoFuncAmount
+		s.InjectionPoint = s.IfAmount + s.CaseAmount + s.FuncAmount + s.ForAmount + s.GoFuncAmount + s.RecoverAmount + s.DeferAmount + s.CondAmount
 	})
 	return s
 }
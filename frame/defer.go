@@ -0,0 +1,97 @@
+package frame
+
+import (
+	"bytes"
+	"go/token"
+)
+
+// DeferFrame is a defer statement, registering is recorded at the statement, and running is recorded
+// when the deferred function is called. If the target is not a function literal, it's deferred unchanged,
+// as recover only works in the function deferred directly, running is recorded by another defer after it,
+// which runs right before the target:
+//
+//	defer f(x) => _g_sdk.Defer(g, e1);defer f(x);defer _g_sdk.Call(e2)
+type DeferFrame struct {
+	*baseFrame
+	deferOffset int // offset of `defer` keyword
+	callEnd     int // offset after the target function call, 0 means anonymous function
+	hasResult   bool
+	deferEvent  string
+	callEvent   string
+	eventVar    string
+}
+
+func NewDeferFrame(path string, deferOffset int) *DeferFrame {
+	return &DeferFrame{baseFrame: NewBaseFrame(path), deferOffset: deferOffset}
+}
+
+func (frame *DeferFrame) Kind() string {
+	return KindDefer
+}
+
+// SetCallEnd sets the end of target function call, which is not a function literal
+func (frame *DeferFrame) SetCallEnd(end token.Position) {
+	frame.callEnd = end.Offset
+}
+
+// MarkResult mark the deferred function literal has result
+func (frame *DeferFrame) MarkResult() {
+	frame.hasResult = true
+}
+
+func (frame *DeferFrame) edits() []edit {
+	register := edit{offset: frame.deferOffset, end: frame.deferOffset, gen: frame.genRegister}
+	if frame.callEnd == 0 {
+		return []edit{
+			register,
+			{offset: frame.bodyBeginOffset, end: frame.bodyBeginOffset, gen: frame.GenBeginning},
+			{offset: frame.bodyEndOffset, end: frame.bodyEndOffset, gen: frame.GenEnding},
+			{offset: frame.blockEndOffset, end: frame.blockEndOffset, gen: popFuncEnv},
+		}
+	}
+	return []edit{register, {offset: frame.callEnd, end: frame.callEnd, gen: frame.genCallDefer}}
+}
+
+func (frame *DeferFrame) genRegister(genEnv *baseEnv) []byte {
+	frame.deferEvent = genEnv.genPointVarName()
+	return []byte(genEnv.genDefer(frame.deferEvent))
+}
+
+// genCallDefer generates the defer after the target, which records running before calling the target
+func (frame *DeferFrame) genCallDefer(genEnv *baseEnv) []byte {
+	frame.callEvent = genEnv.genPointVarName()
+	return []byte(";defer " + genSDKFunCallWithArgs("Call", frame.callEvent))
+}
+
+// GenBeginning is only used when target is an anonymous function, treat as a normal function
+func (frame *DeferFrame) GenBeginning(genEnv *baseEnv) []byte {
+	genEnv.NewFuncEnv()
+	frame.callEvent = genEnv.genPointVarName()
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString(genEnv.genCall(genEnv.GetCurrentGoIDVarName(), frame.callEvent))
+	if genEnv.options.ExitDefer {
+		frame.eventVar = genEnv.genPointVarName()
+		buf.WriteString(genEnv.genExit(frame.eventVar))
+	}
+	return buf.Bytes()
+}
+
+func (frame *DeferFrame) GenEnding(genEnv *baseEnv) []byte {
+	if genEnv.options.ExitDefer || frame.unreachable || frame.hasResult && !frame.isReturn {
+		return nil
+	}
+	frame.eventVar = genEnv.genPointVarName()
+	return []byte(genEnv.genCollect(frame.eventVar))
+}
+
+func (frame *DeferFrame) GenEnv(genEnv *baseEnv) []byte {
+	buffer := bytes.NewBuffer(nil)
+	buffer.WriteString(genEnv.genPoint(frame.deferEvent, frame, EventDefer))
+	if frame.callEvent != "" {
+		buffer.WriteString(genEnv.genPoint(frame.callEvent, frame, EventCall))
+	}
+	if frame.eventVar != "" {
+		buffer.WriteString(genEnv.genPoint(frame.eventVar, frame, EventExit))
+	}
+	return buffer.Bytes()
+}
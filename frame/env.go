@@ -7,12 +7,22 @@ import (
 	"hash/fnv"
 )
 
+// a point id is the file hash in the high bits followed by the point index in the file
+const pointIndexBits = 16
+const pointIndexMask = 1<<pointIndexBits - 1
+const fileHashMask = 1<<(64-pointIndexBits) - 1
+
 type baseEnv struct {
 	filename      string
 	filenameConst string
 	prefix        string
 	pointVarIndex int
 	funcIndex     int
+	tempIndex     int
+	fileHash      uint64            // hash of the stable file name, the high bits of point id
+	pointIDs      map[string]uint64 // point variable name => point id
+	manifest      *Manifest
+	options       Options
 
 	funcEnvStack    [128]funcEnv
 	funcEnvStackTop int
@@ -22,21 +32,43 @@ type funcEnv struct {
 	GoroutineIDVarName string // variable name for current goroutine id
 }
 
-func NewBaseEnv(filename string) *baseEnv {
+func hash(s string) uint32 {
 	var encoder = fnv.New32a()
-	_, _ = encoder.Write([]byte(filename))
-	sum := encoder.Sum32()
-	prefix := fmt.Sprintf("_%s", string(base58.FlickrEncoding.EncodeUint64(uint64(sum))))
+	_, _ = encoder.Write([]byte(s))
+	return encoder.Sum32()
+}
+
+// FileHash returns the hash of a stable file name, which is the high bits of point ids of the file,
+// files of a program must have different hashes
+func FileHash(fileKey string) uint64 {
+	var encoder = fnv.New64a()
+	_, _ = encoder.Write([]byte(fileKey))
+	keyHash := encoder.Sum64()
+	return (keyHash>>(64-pointIndexBits) ^ keyHash) & fileHashMask
+}
+
+// NewBaseEnv creates env for a source file, fileKey is the stable name of the file,
+// which is used to build deterministic point ids
+func NewBaseEnv(filename, fileKey string) *baseEnv {
+	prefix := fmt.Sprintf("_%s", string(base58.FlickrEncoding.EncodeUint64(uint64(hash(filename)))))
 	return &baseEnv{
 		filename:      filename,
 		filenameConst: prefix + "_fName",
 		prefix:        prefix,
+		fileHash:      FileHash(fileKey),
+		pointIDs:      map[string]uint64{},
 	}
 }
 
+// genPointVarName creates a new point, the point id is built from file hash and point index
 func (e *baseEnv) genPointVarName() string {
 	e.pointVarIndex++
-	return fmt.Sprintf("%s_e%d", e.prefix, e.pointVarIndex)
+	if e.pointVarIndex > pointIndexMask {
+		log.Fatalf("too many tracing points in %s", e.filename)
+	}
+	varName := fmt.Sprintf("%s_e%d", e.prefix, e.pointVarIndex)
+	e.pointIDs[varName] = e.fileHash<<pointIndexBits | uint64(e.pointVarIndex)
+	return varName
 }
 
 func (e *baseEnv) genGoIDVarName() string {
@@ -44,6 +76,11 @@ func (e *baseEnv) genGoIDVarName() string {
 	return fmt.Sprintf("%s_g%d", e.prefix, e.funcIndex)
 }
 
+func (e *baseEnv) genTempVarName() string {
+	e.tempIndex++
+	return fmt.Sprintf("%s_t%d", e.prefix, e.tempIndex)
+}
+
 func (e *baseEnv) NewFuncEnv() {
 	if e.funcEnvStackTop == 128 {
 		log.Fatal("too many levels")
@@ -59,6 +96,12 @@ func (e *baseEnv) PopFuncEnv() {
 	e.funcEnvStackTop--
 }
 
+// popFuncEnv is the generator of the edit at the end of function body, it generates nothing
+func popFuncEnv(genEnv *baseEnv) []byte {
+	genEnv.PopFuncEnv()
+	return nil
+}
+
 func (e *baseEnv) GetCurrentGoIDVarName() string {
 	if e.funcEnvStackTop == 0 {
 		log.Fatal("func env was corrupted")
@@ -72,22 +115,84 @@ func (e *baseEnv) GetLastGoIDVarName() string {
 		log.Fatal("func env was corrupted")
 		return ""
 	}
-	fmt.Println(e.funcEnvStackTop)
 	return e.funcEnvStack[e.funcEnvStackTop-2].GoroutineIDVarName
 }
 
-func (e *baseEnv) genPoint(varName string, path string) string {
-	return fmt.Sprintf("var %s = %s\n", varName, genSDKFunCallWithArgs("NewE", e.filenameConst, wrapString(path)))
+func (e *baseEnv) genPoint(varName string, frame Frame, event string) string {
+	id, ok := e.pointIDs[varName]
+	if !ok { // generating is skipped for this frame
+		return ""
+	}
+	path := e.registeredPath(frame)
+	e.manifest.add(id, frame, event, path)
+	return e.genNewE(varName, id, path)
+}
+
+// registeredPath is the path of frame registered by points, line numbers are omitted for stable paths,
+// so traces of different revisions can be compared
+func (e *baseEnv) registeredPath(frame Frame) string {
+	if e.options.StablePaths {
+		return frame.Path()
+	}
+	return frame.getStdPath()
 }
 
+// genConditionPoint generates the point of an operand in condition, the operand is kept in the registered path,
+// so a trace can be reported without manifest
+func (e *baseEnv) genConditionPoint(varName string, frame Frame, index int, expr string) string {
+	id, ok := e.pointIDs[varName]
+	if !ok {
+		return ""
+	}
+	path := conditionPath(e.registeredPath(frame), index, expr)
+	e.manifest.add(id, frame, EventCondition, path)
+	e.manifest.Points[len(e.manifest.Points)-1].Condition = expr
+	return e.genNewE(varName, id, path)
+}
+
+func (e *baseEnv) genNewE(varName string, id uint64, path string) string {
+	return fmt.Sprintf("var %s = %s\n", varName,
+		genSDKFunCallWithArgs("NewE", e.filenameConst, fmt.Sprintf("%#016x", id), wrapString(path)))
+}
+
+// genCall declares the goroutine id variable, it's also marked as used, as there may be no collect in the function
 func (e *baseEnv) genCall(resultVarName string, varName string) string {
-	return fmt.Sprintf("var %s = %s", resultVarName, genSDKFunCallWithArgs("Call", varName))
+	return fmt.Sprintf("var %s = %s _ = %s;", resultVarName, genSDKFunCallWithArgs("Call", varName), resultVarName)
 }
 
+// genCollect starts with `;`, as it may be injected right after a statement in the same line
 func (e *baseEnv) genCollect(varName string) string {
-	return genSDKFunCallWithArgs("C", e.GetCurrentGoIDVarName(), varName)
+	return ";" + genSDKFunCallWithArgs("C", e.GetCurrentGoIDVarName(), varName)
+}
+
+func (e *baseEnv) genBind(parentVarName string) string {
+	return genSDKFunCallWithArgs("Bind", parentVarName)
+}
+
+// genExit records function exit in defer, it also tells whether the function panicked
+func (e *baseEnv) genExit(varName string) string {
+	return "defer " + genSDKFunCallWithArgs("Exit", e.GetCurrentGoIDVarName(), varName)
+}
+
+// genRecover opens the wrapper of a recover call, which is closed after the call
+func (e *baseEnv) genRecover(varName string) string {
+	return fmt.Sprintf("%sRecover(%s, %s, ", SDKPackagePrefix, e.GetCurrentGoIDVarName(), varName)
+}
+
+func (e *baseEnv) genDefer(varName string) string {
+	return genSDKFunCallWithArgs("Defer", e.GetCurrentGoIDVarName(), varName)
+}
+
+// genInit records package initialization when init function returns, the start time is evaluated by defer
+func (e *baseEnv) genInit(varName string) string {
+	return "defer " + genSDKFunCallWithArgs("Init", e.GetCurrentGoIDVarName(), varName, SDKPackagePrefix+"Now()")
+}
+
+// genCondition opens the wrapper of an operand, which is closed after the operand
+func (e *baseEnv) genCondition(varName string) string {
+	return fmt.Sprintf("%sCond(%s, %s, bool(", SDKPackagePrefix, e.GetCurrentGoIDVarName(), varName)
 }
 
-func (e *baseEnv) genBind() string {
-	return genSDKFunCallWithArgs("Bind", e.GetLastGoIDVarName())
+func (e *baseEnv) genFlush() string {
+	return "defer " + genSDKFunCallWithArgs("Flush")
 }
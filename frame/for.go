@@ -13,20 +13,24 @@ func NewForFrame(path string) *ForFrame {
 	return &ForFrame{baseFrame: NewBaseFrame(path)}
 }
 
-func (frame *ForFrame) GenBeginning(genEnv *baseEnv, content []byte) []byte {
-	return content
+func (frame *ForFrame) Kind() string {
+	return KindFor
 }
 
-func (frame *ForFrame) GenEnding(genEnv *baseEnv, content []byte) []byte {
+func (frame *ForFrame) GenBeginning(genEnv *baseEnv) []byte {
+	return nil
+}
+
+func (frame *ForFrame) GenEnding(genEnv *baseEnv) []byte {
+	if frame.unreachable {
+		return nil
+	}
 	frame.varName = genEnv.genPointVarName()
-	buf := bytes.NewBuffer(nil)
-	buf.WriteString(genEnv.genCollect(frame.varName))
-	buf.Write(content)
-	return buf.Bytes()
+	return []byte(genEnv.genCollect(frame.varName))
 }
 
 func (frame *ForFrame) GenEnv(genEnv *baseEnv) []byte {
 	buffer := bytes.NewBuffer(nil)
-	buffer.WriteString(genEnv.genPoint(frame.varName, frame.getStdPath()))
+	buffer.WriteString(genEnv.genPoint(frame.varName, frame, EventBlock))
 	return buffer.Bytes()
 }
@@ -1,24 +1,34 @@
 package frame
 
-import "fmt"
+import (
+	"fmt"
+	"go/token"
+)
 
 type Frame interface {
-	HeadBeginning() int                           // line number of the block beginning,
-	BodyBeginning() int                           // line number of {
-	BodyEnding() int                              // line number of }, or the return statement
-	SetReturn(line int)                           // mark the frame has an explicit return
-	SetUnreachable()                              // set block ending is unreachable
-	Unreachable() bool                            // whether a frame ending is unreachable
-	Path() string                                 // unique frame path
-	IsReturn() bool                               // whether this block contains an explicit return statement
-	GetInner(index int) Frame                     // get inner frame
-	Len() int                                     // amount of inner frames
-	Append(Frame)                                 // append an inner frame
-	SetPosLine(headBegin, bodyBegin, bodyEnd int) // set line number
+	HeadBeginning() int                                  // line number of the block beginning,
+	BodyBeginning() int                                  // line number of {
+	BodyEnding() int                                     // line number of }, or the return statement
+	BodyBeginningOffset() int                            // byte offset to inject code at beginning
+	BodyEndingOffset() int                               // byte offset to inject code at ending
+	SetReturn(pos token.Position)                        // mark the frame has an explicit return
+	SetUnreachable()                                     // set block ending is unreachable
+	Unreachable() bool                                   // whether a frame ending is unreachable
+	Path() string                                        // unique frame path
+	Kind() string                                        // frame kind
+	IsReturn() bool                                      // whether this block contains an explicit return statement
+	GetInner(index int) Frame                            // get inner frame
+	Len() int                                            // amount of inner frames
+	Append(Frame)                                        // append an inner frame
+	SetPos(headBegin, bodyBegin, bodyEnd token.Position) // set position, bodyBegin is right after { or :
 
-	GenBeginning(genEnv *baseEnv, content []byte) []byte // generator function for BodyBeginning
-	GenEnding(genEnv *baseEnv, content []byte) []byte    // generator function for BodyEnding
-	GenEnv(genEnv *baseEnv) []byte                       // generator function for env at end of file
+	GenBeginning(genEnv *baseEnv) []byte // generator function for code injected at BodyBeginningOffset
+	GenEnding(genEnv *baseEnv) []byte    // generator function for code injected at BodyEndingOffset
+	GenEnv(genEnv *baseEnv) []byte       // generator function for env at end of file
+
+	getStdPath() string           // frame path with line numbers
+	setPath(path string)          // rename the frame
+	source(content []byte) []byte // source code of the frame
 
 	fmt.Stringer
 }
@@ -7,7 +7,11 @@ import (
 type FuncFrame struct {
 	*baseFrame
 	hasResult bool
+	isEntry   bool   // entry function of the program, `main.main`
+	isInit    bool   // package initialization function, `init`
+	qualified string // qualified name of a declared function, `main.(*Server).Serve`, empty for function literals
 	callEvent string
+	initEvent string
 	goIDEvent string
 	eventVar  string
 }
@@ -21,36 +25,75 @@ func (frame *FuncFrame) MarkResult() {
 	frame.hasResult = true
 }
 
-func (frame *FuncFrame) GenBeginning(genEnv *baseEnv, content []byte) []byte {
+// MarkEntry mark a function is the entry of the program,
+// collected events will be flushed when it returns
+func (frame *FuncFrame) MarkEntry() {
+	frame.isEntry = true
+}
+
+// MarkInit mark a function is an init function of the package,
+// package initialization is recorded with its duration when it returns
+func (frame *FuncFrame) MarkInit() {
+	frame.isInit = true
+}
+
+// SetQualifiedName sets the qualified name of a declared function, which is its stable path
+func (frame *FuncFrame) SetQualifiedName(name string) {
+	frame.qualified = name
+}
+
+func (frame *FuncFrame) Kind() string {
+	return KindFunc
+}
+
+// edits pop the func env at the end of function body, instead of the ending which may be a return statement,
+// as function literals in the returned expressions are still in the scope of the function
+func (frame *FuncFrame) edits() []edit {
+	return []edit{
+		{offset: frame.bodyBeginOffset, end: frame.bodyBeginOffset, gen: frame.GenBeginning},
+		{offset: frame.bodyEndOffset, end: frame.bodyEndOffset, gen: frame.GenEnding},
+		{offset: frame.blockEndOffset, end: frame.blockEndOffset, gen: popFuncEnv},
+	}
+}
+
+func (frame *FuncFrame) GenBeginning(genEnv *baseEnv) []byte {
 	genEnv.NewFuncEnv()
 	frame.callEvent = genEnv.genPointVarName()
 	buf := bytes.NewBuffer(nil)
-	buf.Write(content)
 	buf.WriteString(genEnv.genCall(genEnv.GetCurrentGoIDVarName(), frame.callEvent))
+	if frame.isEntry {
+		buf.WriteString(genEnv.genFlush())
+	}
+	if genEnv.options.ExitDefer { // deferred after flush, so exit is recorded before flushing
+		frame.eventVar = genEnv.genPointVarName()
+		buf.WriteString(genEnv.genExit(frame.eventVar))
+	}
+	if frame.isInit {
+		frame.initEvent = genEnv.genPointVarName()
+		buf.WriteString(genEnv.genInit(frame.initEvent))
+	}
 	return buf.Bytes()
 }
 
-func (frame *FuncFrame) GenEnding(genEnv *baseEnv, content []byte) []byte {
-	defer genEnv.PopFuncEnv()
+func (frame *FuncFrame) GenEnding(genEnv *baseEnv) []byte {
 	// If a function has a return value, but does not end with return,
 	// it means it's impossible to run to here
-	if frame.hasResult && !frame.isReturn {
-		return content
+	if genEnv.options.ExitDefer || frame.unreachable || frame.hasResult && !frame.isReturn {
+		return nil
 	}
 
 	frame.eventVar = genEnv.genPointVarName()
-
-	buf := bytes.NewBuffer(nil)
-	buf.WriteString(genEnv.genCollect(frame.eventVar))
-	buf.Write(content)
-	return buf.Bytes()
+	return []byte(genEnv.genCollect(frame.eventVar))
 }
 
 func (frame *FuncFrame) GenEnv(genEnv *baseEnv) []byte {
 	buffer := bytes.NewBuffer(nil)
-	buffer.WriteString(genEnv.genPoint(frame.callEvent, frame.getStdPath()))
+	buffer.WriteString(genEnv.genPoint(frame.callEvent, frame, EventCall))
+	if frame.initEvent != "" {
+		buffer.WriteString(genEnv.genPoint(frame.initEvent, frame, EventInit))
+	}
 	if frame.eventVar != "" {
-		buffer.WriteString(genEnv.genPoint(frame.eventVar, frame.getStdPath()))
+		buffer.WriteString(genEnv.genPoint(frame.eventVar, frame, EventExit))
 	}
 	return buffer.Bytes()
 }
@@ -60,5 +103,11 @@ func (frame *FuncFrame) String() string {
 	if frame.hasResult {
 		str += " [result]"
 	}
+	if frame.isEntry {
+		str += " [entry]"
+	}
+	if frame.isInit {
+		str += " [init]"
+	}
 	return str
 }
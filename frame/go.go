@@ -2,66 +2,164 @@ package frame
 
 import (
 	"bytes"
-	"fmt"
+	"strings"
 )
 
 type GoFuncFrame struct {
 	*baseFrame
-	target    string // target function name, empty means anonymous function
+	call      *GoCall // target function call, nil means anonymous function
 	callEvent string
 	eventVar  string
 }
 
+// GoCall is the source of a go statement whose target is not a function literal
+type GoCall struct {
+	Go       int     // offset of `go` keyword
+	End      int     // offset right after the call
+	Fun      GoArg   // function value
+	Args     []GoArg // arguments
+	Ellipsis bool    // the last argument is followed by `...`
+}
+
+// GoArg is an expression in go statement, expression bound to a temporary is kept in place,
+// so frames inside it are still generated
+type GoArg struct {
+	Expr   string // source of the expression
+	Offset int
+	End    int
+	Bind   bool   // expression must be evaluated at the statement, before starting the goroutine
+	Type   string // type of the temporary bound, it's the default type of the expression if empty
+}
+
 func NewGoFuncFrame(path string) *GoFuncFrame {
 	return &GoFuncFrame{baseFrame: NewBaseFrame(path)}
 }
 
-func (frame *GoFuncFrame) SetTarget(target string) {
-	frame.target = target
+func (frame *GoFuncFrame) Kind() string {
+	return KindGoFunc
+}
+
+func (frame *GoFuncFrame) SetCall(call GoCall) {
+	frame.call = &call
+}
+
+func (frame *GoFuncFrame) edits() []edit {
+	if frame.call == nil {
+		return []edit{
+			{offset: frame.bodyBeginOffset, end: frame.bodyBeginOffset, gen: frame.GenBeginning},
+			{offset: frame.bodyEndOffset, end: frame.bodyEndOffset, gen: frame.GenEnding},
+			{offset: frame.blockEndOffset, end: frame.blockEndOffset, gen: popFuncEnv},
+		}
+	}
+	return callEdits(frame.call, frame.genGoStmt)
+}
+
+// genGoStmt generates the new go statement, the goroutine is bound to current goroutine before calling
+func (frame *GoFuncFrame) genGoStmt(genEnv *baseEnv, call string) []byte {
+	bind := genEnv.genBind(genEnv.GetCurrentGoIDVarName())
+	return []byte("go func(){" + bind + call + "}()")
 }
 
-func (frame *GoFuncFrame) GenBeginning(genEnv *baseEnv, content []byte) []byte {
+// callEdits rewrite a go statement whose target is not a function literal, the function value and
+// arguments are evaluated at the statement as before, by binding them to temporaries,
+// which are declared with the type of parameter if it's known:
+//
+//	go function(x, 1) => {var t1 = x; go func(){function(t1, 1)}()}
+//	go function(1 << n) => {var t1 uint64 = 1 << n; go func(){function(t1)}()}
+//
+// bound expressions are kept in place, the others are removed and copied into the new call,
+// stmt generates the new statement from the call
+func callEdits(call *GoCall, stmt func(genEnv *baseEnv, call string) []byte) []edit {
+	var bound []GoArg
+	for _, expr := range append([]GoArg{call.Fun}, call.Args...) {
+		if expr.Bind {
+			bound = append(bound, expr)
+		}
+	}
+	if len(bound) == 0 {
+		return []edit{{offset: call.Go, end: call.End, gen: func(genEnv *baseEnv) []byte {
+			return stmt(genEnv, call.expr(nil))
+		}}}
+	}
+	var tempNames []string
+	edits := []edit{{offset: call.Go, end: bound[0].Offset, gen: func(genEnv *baseEnv) []byte {
+		tempNames = tempNames[:0]
+		for range bound {
+			tempNames = append(tempNames, genEnv.genTempVarName())
+		}
+		return []byte("{" + declareTemp(tempNames[0], bound[0]))
+	}}}
+	for i := 1; i < len(bound); i++ {
+		i := i
+		edits = append(edits, edit{offset: bound[i-1].End, end: bound[i].Offset, gen: func(*baseEnv) []byte {
+			return []byte("; " + declareTemp(tempNames[i], bound[i]))
+		}})
+	}
+	return append(edits, edit{offset: bound[len(bound)-1].End, end: call.End, gen: func(genEnv *baseEnv) []byte {
+		return append([]byte("; "), append(stmt(genEnv, call.expr(tempNames)), '}')...)
+	}})
+}
+
+// declareTemp declares a temporary for a bound expression, the expression follows it
+func declareTemp(name string, expr GoArg) string {
+	if expr.Type == "" {
+		return "var " + name + " = "
+	}
+	return "var " + name + " " + expr.Type + " = "
+}
+
+// expr generates the call expression, bound expressions are replaced with temporaries
+func (call *GoCall) expr(tempNames []string) string {
+	next := func(expr GoArg) string {
+		if !expr.Bind {
+			return expr.Expr
+		}
+		name := tempNames[0]
+		tempNames = tempNames[1:]
+		return name
+	}
+	fun := next(call.Fun)
+	args := make([]string, 0, len(call.Args))
+	for _, arg := range call.Args {
+		args = append(args, next(arg))
+	}
+	ellipsis := ""
+	if call.Ellipsis {
+		ellipsis = "..."
+	}
+	return fun + "(" + strings.Join(args, ", ") + ellipsis + ")"
+}
+
+// GenBeginning is only used when target is an anonymous function, treat as a normal function
+func (frame *GoFuncFrame) GenBeginning(genEnv *baseEnv) []byte {
 	genEnv.NewFuncEnv()
 	buf := bytes.NewBuffer(nil)
-	if frame.target != "" { // go function(xxx) => go func(){function(xxx)}
-		replaceTarget := fmt.Sprintf("func(){%s%s", genEnv.genBind(), frame.target)
-		buf.Write(
-			bytes.Replace(content, []byte(frame.target),
-				[]byte(replaceTarget),
-				1),
-		)
-		buf.WriteString("}()")
-	} else { // empty means target is an anonymous function, treat as a normal function
-		buf.Write(content)
-		buf.WriteString(genEnv.genBind())
-		frame.callEvent = genEnv.genPointVarName()
-		buf.WriteString(genEnv.genCall(genEnv.GetCurrentGoIDVarName(), frame.callEvent))
+	buf.WriteString(genEnv.genBind(genEnv.GetLastGoIDVarName()))
+	frame.callEvent = genEnv.genPointVarName()
+	buf.WriteString(genEnv.genCall(genEnv.GetCurrentGoIDVarName(), frame.callEvent))
+	if genEnv.options.ExitDefer {
+		frame.eventVar = genEnv.genPointVarName()
+		buf.WriteString(genEnv.genExit(frame.eventVar))
 	}
-
 	return buf.Bytes()
 }
 
-func (frame *GoFuncFrame) GenEnding(genEnv *baseEnv, content []byte) []byte {
-	defer genEnv.PopFuncEnv()
-	// we don't need to generate trace code for go func call if target function isn't a Func Lit
-	if frame.target != "" {
-		return content
+func (frame *GoFuncFrame) GenEnding(genEnv *baseEnv) []byte {
+	if genEnv.options.ExitDefer || frame.unreachable {
+		return nil
 	}
 	// as go called function must have no return value, we can add trace code to all anonymous function
-	buf := bytes.NewBuffer(nil)
 	frame.eventVar = genEnv.genPointVarName()
-	buf.WriteString(genEnv.genCollect(frame.eventVar))
-	buf.Write(content)
-	return buf.Bytes()
+	return []byte(genEnv.genCollect(frame.eventVar))
 }
 
 func (frame *GoFuncFrame) GenEnv(genEnv *baseEnv) []byte {
 	buffer := bytes.NewBuffer(nil)
 	if frame.callEvent != "" {
-		buffer.WriteString(genEnv.genPoint(frame.callEvent, frame.getStdPath()))
+		buffer.WriteString(genEnv.genPoint(frame.callEvent, frame, EventCall))
 	}
 	if frame.eventVar != "" {
-		buffer.WriteString(genEnv.genPoint(frame.eventVar, frame.getStdPath()))
+		buffer.WriteString(genEnv.genPoint(frame.eventVar, frame, EventExit))
 	}
 	return buffer.Bytes()
 }
@@ -15,20 +15,24 @@ func NewIfElseFrame(path string) *IfElseFrame {
 	}
 }
 
-func (frame *IfElseFrame) GenBeginning(genEnv *baseEnv, content []byte) []byte {
-	return content
+func (frame *IfElseFrame) Kind() string {
+	return KindIfElse
 }
 
-func (frame *IfElseFrame) GenEnding(genEnv *baseEnv, content []byte) []byte {
+func (frame *IfElseFrame) GenBeginning(genEnv *baseEnv) []byte {
+	return nil
+}
+
+func (frame *IfElseFrame) GenEnding(genEnv *baseEnv) []byte {
+	if frame.unreachable {
+		return nil
+	}
 	frame.varName = genEnv.genPointVarName()
-	buf := bytes.NewBuffer(nil)
-	buf.WriteString(genEnv.genCollect(frame.varName))
-	buf.Write(content)
-	return buf.Bytes()
+	return []byte(genEnv.genCollect(frame.varName))
 }
 
 func (frame *IfElseFrame) GenEnv(genEnv *baseEnv) []byte {
 	buffer := bytes.NewBuffer(nil)
-	buffer.WriteString(genEnv.genPoint(frame.varName, frame.getStdPath()))
+	buffer.WriteString(genEnv.genPoint(frame.varName, frame, EventBlock))
 	return buffer.Bytes()
 }
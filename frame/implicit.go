@@ -0,0 +1,52 @@
+package frame
+
+import (
+	"bytes"
+)
+
+// ImplicitFrame is a branch not written in source code, it's synthesized in branch coverage mode,
+// so a branch not taken can be told from a statement not reached:
+//
+//	if x {...}              => if x {...} else {_g_sdk.C(g, e)}
+//	switch x {case 1: ...}  => switch x {case 1: ... default:_g_sdk.C(g, e)}
+type ImplicitFrame struct {
+	*baseFrame
+	kind    string // kind of the written branches, if or case
+	prefix  string
+	suffix  string
+	varName string
+}
+
+// NewImplicitElseFrame creates the else branch of an if statement, it's injected right after the last if body
+func NewImplicitElseFrame(path string) *ImplicitFrame {
+	return &ImplicitFrame{baseFrame: NewBaseFrame(path), kind: KindIfElse, prefix: " else {", suffix: "}"}
+}
+
+// NewImplicitDefaultFrame creates the default clause of a switch statement, it's injected right before `}` of the switch
+func NewImplicitDefaultFrame(path string) *ImplicitFrame {
+	return &ImplicitFrame{baseFrame: NewBaseFrame(path), kind: KindCase, prefix: "default:"}
+}
+
+func (frame *ImplicitFrame) Kind() string {
+	return frame.kind
+}
+
+func (frame *ImplicitFrame) edits() []edit {
+	// the else is right after `}` of if, which may also be the ending of parent frame
+	return []edit{{offset: frame.bodyBeginOffset, end: frame.bodyBeginOffset, gen: frame.GenBeginning, first: true}}
+}
+
+func (frame *ImplicitFrame) GenBeginning(genEnv *baseEnv) []byte {
+	frame.varName = genEnv.genPointVarName()
+	return []byte(frame.prefix + genEnv.genCollect(frame.varName) + frame.suffix)
+}
+
+func (frame *ImplicitFrame) GenEnding(genEnv *baseEnv) []byte {
+	return nil
+}
+
+func (frame *ImplicitFrame) GenEnv(genEnv *baseEnv) []byte {
+	buffer := bytes.NewBuffer(nil)
+	buffer.WriteString(genEnv.genPoint(frame.varName, frame, EventImplicit))
+	return buffer.Bytes()
+}
@@ -0,0 +1,130 @@
+package frame
+
+import (
+	"bytes"
+)
+
+// exit reasons of a loop, except the condition which is recorded after the loop
+const (
+	LoopBreak  = "Break"  // break of the loop, it's recorded after the loop with the condition
+	LoopReturn = "Return" // return from the function
+	LoopJump   = "Jump"   // goto, break and continue of an outer label
+)
+
+// LoopFrame is a for or for-range in loop mode, or a range over function iterator in any mode, the loop is wrapped in a block with an iteration counter,
+// and it's recorded once when it finishes instead of every iteration:
+//
+//	{var _t1 _g_sdk.Loop; for ... {_t1.Next(); ... _t1.Return(g, e); return ...}; _t1.Exit(g, e)}
+//
+// a labeled loop is wrapped with its label, so break and continue of the label still work.
+// The body of a function iterator runs in the yield function, which may be called in another goroutine,
+// so it gets the goroutine id of its own at the beginning of every iteration
+type LoopFrame struct {
+	*baseFrame
+	stmtOffset int // beginning of the loop statement, including its label
+	stmtEnd    int
+	reachable  bool // code after the loop is reachable, finishing by condition or break is recorded there
+	iterator   bool // range over a function iterator
+	exits      []loopExit
+	loopVar    string
+	eventVar   string
+}
+
+// loopExit is a statement which leaves the loop
+type loopExit struct {
+	offset int
+	reason string // method of sdk.Loop called before the statement
+}
+
+func NewLoopFrame(path string, stmtOffset, stmtEnd int) *LoopFrame {
+	return &LoopFrame{baseFrame: NewBaseFrame(path), stmtOffset: stmtOffset, stmtEnd: stmtEnd}
+}
+
+// MarkReachable marks code after the loop is reachable
+func (frame *LoopFrame) MarkReachable() {
+	frame.reachable = true
+}
+
+// AddExit adds a statement in the loop body which leaves the loop
+func (frame *LoopFrame) AddExit(offset int, reason string) {
+	frame.exits = append(frame.exits, loopExit{offset: offset, reason: reason})
+}
+
+// MarkIterator marks the loop ranges over a function iterator, the body runs in the yield function,
+// so the loop is recorded after the iterator returns, and a break is recorded as the yield function returns false
+func (frame *LoopFrame) MarkIterator() {
+	frame.iterator = true
+}
+
+func (frame *LoopFrame) Kind() string {
+	if frame.iterator {
+		return KindRangeFunc
+	}
+	return KindLoop
+}
+
+// edits closes the wrapped block before other edits at the end, such as the ending of parent frame
+func (frame *LoopFrame) edits() []edit {
+	edits := []edit{
+		{offset: frame.stmtOffset, end: frame.stmtOffset, gen: frame.genDeclare},
+		{offset: frame.bodyBeginOffset, end: frame.bodyBeginOffset, gen: frame.GenBeginning},
+		{offset: frame.stmtEnd, end: frame.stmtEnd, gen: frame.GenEnding, first: true},
+	}
+	if frame.iterator {
+		edits = append(edits, edit{offset: frame.blockEndOffset, end: frame.blockEndOffset, gen: popFuncEnv})
+	}
+	for _, exit := range frame.exits {
+		reason := exit.reason
+		edits = append(edits, edit{offset: exit.offset, end: exit.offset, gen: func(genEnv *baseEnv) []byte {
+			return []byte(frame.genExit(genEnv, reason))
+		}})
+	}
+	return edits
+}
+
+func (frame *LoopFrame) genDeclare(genEnv *baseEnv) []byte {
+	frame.loopVar = genEnv.genTempVarName()
+	frame.eventVar = genEnv.genPointVarName()
+	return []byte("{var " + frame.loopVar + " " + SDKPackagePrefix + "Loop;")
+}
+
+// genExit generates the record of leaving the loop, a break is only marked,
+// as the loop is recorded after it finishes
+func (frame *LoopFrame) genExit(genEnv *baseEnv, reason string) string {
+	if reason == LoopBreak {
+		return frame.loopVar + ".Break();"
+	}
+	return frame.loopVar + "." + reason + "(" + genEnv.GetCurrentGoIDVarName() + ", " + frame.eventVar + ");"
+}
+
+func (frame *LoopFrame) GenBeginning(genEnv *baseEnv) []byte {
+	if !frame.iterator {
+		return []byte(frame.loopVar + ".Next();")
+	}
+	genEnv.NewFuncEnv()
+	goID := genEnv.GetCurrentGoIDVarName()
+	return []byte("var " + goID + " = " + SDKPackagePrefix + "Goroutine(); _ = " + goID + ";" + frame.loopVar + ".Next();")
+}
+
+// GenEnding records the loop after it finishes by condition or break,
+// it must be omitted if code after the loop is unreachable, or the function may miss a return
+func (frame *LoopFrame) GenEnding(genEnv *baseEnv) []byte {
+	if !frame.reachable {
+		return []byte("}")
+	}
+	return []byte(";" + frame.loopVar + ".Exit(" + genEnv.GetCurrentGoIDVarName() + ", " + frame.eventVar + ")}")
+}
+
+func (frame *LoopFrame) GenEnv(genEnv *baseEnv) []byte {
+	buffer := bytes.NewBuffer(nil)
+	buffer.WriteString(genEnv.genPoint(frame.eventVar, frame, EventLoop))
+	return buffer.Bytes()
+}
+
+func (frame *LoopFrame) String() string {
+	str := frame.baseFrame.String()
+	if !frame.reachable {
+		str += " [endless]"
+	}
+	return str
+}
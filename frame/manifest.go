@@ -0,0 +1,98 @@
+package frame
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+)
+
+const ManifestVersion = 1
+
+// point events, tell where a point is collected in its frame
+const (
+	EventCall      = "call"      // function is called
+	EventExit      = "exit"      // function returns
+	EventBlock     = "block"     // block is executed
+	EventRecover   = "recover"   // recover is called
+	EventDefer     = "defer"     // function is deferred
+	EventInit      = "init"      // package init function returns
+	EventImplicit  = "implicit"  // branch not written in source is taken, such as if without else
+	EventCondition = "condition" // operand of a condition is evaluated
+	EventLoop      = "loop"      // loop finishes, with iterations and the exit reason
+	EventType      = "type"      // case of type switch is chosen, with the dynamic type matched
+	EventSelect    = "select"    // case of select is chosen, with the time blocked
+)
+
+// Manifest describes all tracing points generated for a source file,
+// it can be used to decode a trace without the instrumented binary
+type Manifest struct {
+	Version int             `json:"version"`
+	File    string          `json:"file"`   // stable file name, relative to the module root when possible
+	Source  string          `json:"source"` // absolute path of the source file when generating
+	Package string          `json:"package"`
+	Points  []ManifestPoint `json:"points"`
+}
+
+type ManifestPoint struct {
+	ID         uint64 `json:"id"`
+	Path       string `json:"path"`  // frame path
+	Kind       string `json:"kind"`  // frame kind
+	Event      string `json:"event"` // where the point is collected in the frame
+	HeadBegin  int    `json:"head_begin"`
+	BodyBegin  int    `json:"body_begin"`
+	BodyEnd    int    `json:"body_end"`
+	Condition  string `json:"condition,omitempty"`  // source of the operand, only for condition points
+	Registered string `json:"registered,omitempty"` // path registered in traces, omitted if it's the frame path
+}
+
+// TracePath returns the path of point registered in traces
+func (p ManifestPoint) TracePath() string {
+	if p.Registered != "" {
+		return p.Registered
+	}
+	return p.Path
+}
+
+func (m *Manifest) add(id uint64, frame Frame, event, registered string) {
+	if registered == frame.Path() {
+		registered = ""
+	}
+	m.Points = append(m.Points, ManifestPoint{
+		ID:         id,
+		Path:       frame.Path(),
+		Kind:       frame.Kind(),
+		Event:      event,
+		HeadBegin:  frame.HeadBeginning(),
+		BodyBegin:  frame.BodyBeginning(),
+		BodyEnd:    frame.BodyEnding(),
+		Registered: registered,
+	})
+}
+
+func (m *Manifest) sort() {
+	sort.Slice(m.Points, func(i, j int) bool {
+		return m.Points[i].ID < m.Points[j].ID
+	})
+}
+
+// WriteFile saves the manifest in json format
+func (m *Manifest) WriteFile(filename string) error {
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(content, '\n'), 0644)
+}
+
+// LoadManifest reads a manifest written by WriteFile
+func LoadManifest(filename string) (*Manifest, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err = json.Unmarshal(content, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
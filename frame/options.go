@@ -0,0 +1,10 @@
+package frame
+
+// Options controls what instrumentation code is generated
+type Options struct {
+	ExitDefer         bool // record function exit by an injected defer, so every return path and panic is covered
+	BranchCoverage    bool // synthesize else of if and default of switch, so branches not taken are recorded
+	ConditionCoverage bool // record every operand of && and || in conditions of if, for and tagless switch
+	LoopCount         bool // record a loop once it finishes with the iterations and the reason, instead of every iteration
+	StablePaths       bool // name frames by qualified function name and fingerprint of source, instead of index and lines
+}
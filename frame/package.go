@@ -3,23 +3,26 @@ package frame
 import (
 	"bytes"
 	"fmt"
+	"go/token"
 )
 
 type PackageFrame struct {
 	name     string   // package name
 	filename string   // source file name, with path
+	fileKey  string   // stable source file name, used to build point ids
 	imports  []string // packages imported in this file
 	*baseFrame
 }
 
-func NewPackageFrame(filename, packageName string, packageBegin, packageEnd int) *PackageFrame {
+func NewPackageFrame(filename, fileKey, packageName string, packageBegin, packageEnd token.Position) *PackageFrame {
 	packageFrame := &PackageFrame{
 		name:      packageName,
 		filename:  filename,
+		fileKey:   fileKey,
 		imports:   make([]string, 0),
 		baseFrame: NewBaseFrame(packageName),
 	}
-	packageFrame.SetPosLine(packageBegin, packageBegin, packageEnd)
+	packageFrame.SetPos(packageBegin, packageBegin, packageEnd)
 	return packageFrame
 }
 
@@ -27,31 +30,35 @@ func (frame *PackageFrame) Import(path string) {
 	frame.imports = append(frame.imports, path)
 }
 
-func (frame *PackageFrame) GenBeginning(genEnv *baseEnv, content []byte) []byte {
-	buf := bytes.NewBuffer(nil)
-	buf.WriteString(fmt.Sprintf("//line %s:%d\n", frame.filename, 1)) // need reset filename
-	buf.Write(content)
-	if len(frame.InnerFrame) == 0 {
-		return buf.Bytes()
+func (frame *PackageFrame) Kind() string {
+	return KindPackage
+}
+
+func (frame *PackageFrame) edits() []edit {
+	return []edit{
+		{offset: 0, end: 0, gen: frame.genLineDirective},
+		{offset: frame.bodyBeginOffset, end: frame.bodyBeginOffset, gen: frame.GenBeginning},
 	}
-	// do some import work
+}
+
+// genLineDirective resets filename, so positions in generated file point to the source file
+func (frame *PackageFrame) genLineDirective(genEnv *baseEnv) []byte {
+	return []byte(fmt.Sprintf("//line %s:%d\n", frame.filename, 1))
+}
+
+// GenBeginning imports the sdk package right after package clause, in the same line,
+// so line numbers are not changed
+func (frame *PackageFrame) GenBeginning(genEnv *baseEnv) []byte {
 	for _, pack := range frame.imports {
 		if pack == SDKPackage {
-			return buf.Bytes()
+			return nil
 		}
 	}
-	buf.WriteRune('\n')
-	buf.WriteString("import ")
-	buf.WriteString(SDKPackage)
-	buf.WriteRune('\n')
-	buf.WriteString(fmt.Sprintf("//line %s:%d", frame.filename, 2)) // need reset line number
-	return buf.Bytes()
+	return []byte("; import " + SDKPackage)
 }
 
-func (frame *PackageFrame) GenEnding(genEnv *baseEnv, content []byte) []byte {
-	buf := bytes.NewBuffer(nil)
-	buf.Write(content)
-	return buf.Bytes()
+func (frame *PackageFrame) GenEnding(genEnv *baseEnv) []byte {
+	return nil
 }
 
 func (frame *PackageFrame) GenEnv(genEnv *baseEnv) []byte {
@@ -63,5 +70,12 @@ func (frame *PackageFrame) GenEnv(genEnv *baseEnv) []byte {
 }
 
 func (frame *PackageFrame) getEnv() *baseEnv {
-	return NewBaseEnv(frame.filename)
+	env := NewBaseEnv(frame.filename, frame.fileKey)
+	env.manifest = &Manifest{
+		Version: ManifestVersion,
+		File:    frame.fileKey,
+		Source:  frame.filename,
+		Package: frame.name,
+	}
+	return env
 }
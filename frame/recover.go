@@ -0,0 +1,47 @@
+package frame
+
+import (
+	"bytes"
+)
+
+// RecoverFrame is a call of builtin recover, the call is wrapped to record whether a panic is recovered:
+//
+//	recover() => _g_sdk.Recover(g, e, recover())
+//
+// recover is still called by the deferred function directly, so it works as before
+type RecoverFrame struct {
+	*baseFrame
+	varName string
+}
+
+func NewRecoverFrame(path string) *RecoverFrame {
+	return &RecoverFrame{
+		baseFrame: NewBaseFrame(path),
+	}
+}
+
+func (frame *RecoverFrame) Kind() string {
+	return KindRecover
+}
+
+func (frame *RecoverFrame) edits() []edit {
+	return []edit{
+		{offset: frame.bodyBeginOffset, end: frame.bodyBeginOffset, gen: frame.GenBeginning},
+		{offset: frame.bodyEndOffset, end: frame.bodyEndOffset, gen: frame.GenEnding},
+	}
+}
+
+func (frame *RecoverFrame) GenBeginning(genEnv *baseEnv) []byte {
+	frame.varName = genEnv.genPointVarName()
+	return []byte(genEnv.genRecover(frame.varName))
+}
+
+func (frame *RecoverFrame) GenEnding(genEnv *baseEnv) []byte {
+	return []byte(")")
+}
+
+func (frame *RecoverFrame) GenEnv(genEnv *baseEnv) []byte {
+	buffer := bytes.NewBuffer(nil)
+	buffer.WriteString(genEnv.genPoint(frame.varName, frame, EventRecover))
+	return buffer.Bytes()
+}
@@ -0,0 +1,252 @@
+package frame
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+)
+
+// Remap maps frame paths of an old manifest to a newer one of the same source file,
+// it's written when stable paths are changed, so traces of the old revision can be translated
+type Remap struct {
+	File    string       `json:"file"`
+	Changed []PathChange `json:"changed,omitempty"` // frames changed, matched by structure
+	Removed []string     `json:"removed,omitempty"` // frames not found in the new manifest
+	Added   []string     `json:"added,omitempty"`   // frames not found in the old manifest
+}
+
+type PathChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// manifestFrame is a frame in manifest, points of a frame share its path
+type manifestFrame struct {
+	path string
+	kind string
+	line int
+}
+
+func (m *Manifest) frames() []manifestFrame {
+	var frames []manifestFrame
+	seen := map[string]bool{}
+	for _, point := range m.Points {
+		if !seen[point.Path] {
+			seen[point.Path] = true
+			frames = append(frames, manifestFrame{path: point.Path, kind: point.Kind, line: point.HeadBegin})
+		}
+	}
+	sort.SliceStable(frames, func(i, j int) bool {
+		return frames[i].line < frames[j].line
+	})
+	return frames
+}
+
+// RemapFrom matches frames of an old manifest with paths not found in m. Frames of the same scope are aligned
+// by paths not changed, then frames changed between two aligned frames are matched if they have the same name
+// and kind, in the order of their lines
+func (m *Manifest) RemapFrom(old *Manifest) *Remap {
+	return m.RemapWithLines(old, nil)
+}
+
+// RemapWithLines is RemapFrom with a line mapping from the old source to the new one, such as built from a diff,
+// frames of the same kind beginning at mapped lines are matched first, as their heads are not changed.
+// It's required to remap manifests without stable paths, which are changed by any edit before the frame
+func (m *Manifest) RemapWithLines(old *Manifest, lines func(line int) (int, bool)) *Remap {
+	remap := &Remap{File: m.File}
+	oldFrames, newFrames := old.frames(), m.frames()
+	if lines != nil {
+		oldFrames, newFrames = remap.matchLines(oldFrames, newFrames, lines)
+	}
+	oldScopes, scopes := groupScopes(oldFrames), groupScopes(newFrames)
+	for _, scope := range scopes.names {
+		oldFrames := oldScopes.frames[scope]
+		newFrames := scopes.frames[scope]
+		var lastOld, lastNew int
+		for _, anchor := range align(oldFrames, newFrames) {
+			remap.matchGap(oldFrames[lastOld:anchor[0]], newFrames[lastNew:anchor[1]])
+			lastOld, lastNew = anchor[0]+1, anchor[1]+1
+		}
+		remap.matchGap(oldFrames[lastOld:], newFrames[lastNew:])
+	}
+	for _, scope := range oldScopes.names {
+		if _, ok := scopes.frames[scope]; !ok {
+			for _, frame := range oldScopes.frames[scope] {
+				remap.Removed = append(remap.Removed, frame.path)
+			}
+		}
+	}
+	return remap
+}
+
+// matchLines matches frames by mapped lines, frames not matched are returned
+func (r *Remap) matchLines(oldFrames, newFrames []manifestFrame, lines func(line int) (int, bool)) ([]manifestFrame, []manifestFrame) {
+	matched := make([]bool, len(newFrames))
+	var oldLeft []manifestFrame
+	for _, frame := range oldFrames {
+		line, ok := lines(frame.line)
+		found := false
+		for i, candidate := range newFrames {
+			if ok && !matched[i] && candidate.line == line && candidate.kind == frame.kind {
+				matched[i], found = true, true
+				if candidate.path != frame.path {
+					r.Changed = append(r.Changed, PathChange{Old: frame.path, New: candidate.path})
+				}
+				break
+			}
+		}
+		if !found {
+			oldLeft = append(oldLeft, frame)
+		}
+	}
+	var newLeft []manifestFrame
+	for i, frame := range newFrames {
+		if !matched[i] {
+			newLeft = append(newLeft, frame)
+		}
+	}
+	return oldLeft, newLeft
+}
+
+// frameScopes groups frames by scope, scopes are in the order of first appearance
+type frameScopes struct {
+	names  []string
+	frames map[string][]manifestFrame
+}
+
+func groupScopes(frames []manifestFrame) frameScopes {
+	scopes := frameScopes{frames: map[string][]manifestFrame{}}
+	for _, frame := range frames {
+		scope, _, _, ok := SplitStablePath(frame.path)
+		if !ok {
+			scope = frame.path // declared function, it's the scope of frames in it
+		}
+		if _, ok := scopes.frames[scope]; !ok {
+			scopes.names = append(scopes.names, scope)
+		}
+		scopes.frames[scope] = append(scopes.frames[scope], frame)
+	}
+	return scopes
+}
+
+// align returns indexes of frames with the same path in the longest common subsequence
+func align(oldFrames, newFrames []manifestFrame) [][2]int {
+	lengths := make([][]int, len(oldFrames)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(newFrames)+1)
+	}
+	for i := len(oldFrames) - 1; i >= 0; i-- {
+		for j := len(newFrames) - 1; j >= 0; j-- {
+			if oldFrames[i].path == newFrames[j].path {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	var anchors [][2]int
+	for i, j := 0, 0; i < len(oldFrames) && j < len(newFrames); {
+		switch {
+		case oldFrames[i].path == newFrames[j].path:
+			anchors = append(anchors, [2]int{i, j})
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return anchors
+}
+
+// matchGap matches frames changed between two aligned frames by name and kind
+func (r *Remap) matchGap(oldFrames, newFrames []manifestFrame) {
+	matched := make([]bool, len(oldFrames))
+	for _, frame := range newFrames {
+		found := false
+		for i, candidate := range oldFrames {
+			if !matched[i] && sameFrameName(candidate, frame) {
+				matched[i], found = true, true
+				r.Changed = append(r.Changed, PathChange{Old: candidate.path, New: frame.path})
+				break
+			}
+		}
+		if !found {
+			r.Added = append(r.Added, frame.path)
+		}
+	}
+	for i, frame := range oldFrames {
+		if !matched[i] {
+			r.Removed = append(r.Removed, frame.path)
+		}
+	}
+}
+
+func sameFrameName(a, b manifestFrame) bool {
+	_, nameA, _, okA := SplitStablePath(a.path)
+	_, nameB, _, okB := SplitStablePath(b.path)
+	return okA && okB && nameA == nameB && a.kind == b.kind
+}
+
+// PointsFrom maps point ids of an old manifest to m by the remap, points of a frame are matched by their events
+// in the order of ids, points of removed frames are not mapped
+func (m *Manifest) PointsFrom(old *Manifest, remap *Remap) map[uint64]uint64 {
+	paths := map[string]string{}
+	for _, point := range m.Points {
+		paths[point.Path] = point.Path
+	}
+	for _, change := range remap.Changed {
+		paths[change.Old] = change.New
+	}
+	for _, path := range remap.Removed {
+		delete(paths, path)
+	}
+	type pointKey struct {
+		path  string
+		event string
+		index int
+	}
+	keys := func(m *Manifest, path func(string) (string, bool)) map[pointKey]uint64 {
+		ids := map[pointKey]uint64{}
+		indexes := map[pointKey]int{}
+		for _, point := range m.Points {
+			p, ok := path(point.Path)
+			if !ok {
+				continue
+			}
+			key := pointKey{path: p, event: point.Event}
+			key.index = indexes[key]
+			indexes[key]++
+			ids[key] = point.ID
+		}
+		return ids
+	}
+	newIDs := keys(m, func(path string) (string, bool) { return path, true })
+	ids := map[uint64]uint64{}
+	for key, oldID := range keys(old, func(path string) (string, bool) {
+		path, ok := paths[path]
+		return path, ok
+	}) {
+		if id, ok := newIDs[key]; ok {
+			ids[oldID] = id
+		}
+	}
+	return ids
+}
+
+// Empty reports whether no path is changed
+func (r *Remap) Empty() bool {
+	return len(r.Changed) == 0 && len(r.Removed) == 0 && len(r.Added) == 0
+}
+
+// WriteFile saves the remap in json format
+func (r *Remap) WriteFile(filename string) error {
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(content, '\n'), 0644)
+}
@@ -0,0 +1,92 @@
+package frame
+
+import (
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// StabilizePaths renames frames with paths surviving unrelated edits. A declared function is named by
+// its qualified name, other frames are named by the declared function they are in, their name and
+// the fingerprint of their own source, so a path changes only if the block itself changes:
+//
+//	main.(*Server).Serve.if@3f2a9c1b
+//
+// identical blocks in the same function are numbered by their order, `main.run.if@3f2a9c1b#2`
+func (root *Context) StabilizePaths(source []byte) {
+	seen := map[string]int{}
+	unique := func(path string) string {
+		seen[path]++
+		if n := seen[path]; n > 1 {
+			return path + "#" + strconv.Itoa(n)
+		}
+		return path
+	}
+	var walk func(parent Frame, scope string)
+	walk = func(parent Frame, scope string) {
+		for i := 0; i < parent.Len(); i++ {
+			inner := parent.GetInner(i)
+			innerScope := scope
+			var path string
+			if funcFrame, ok := inner.(*FuncFrame); ok && funcFrame.qualified != "" {
+				path = unique(funcFrame.qualified)
+				innerScope = path
+			} else {
+				path = unique(scope + "." + frameName(inner.Path()) + "@" + fingerprint(inner.source(source)))
+			}
+			walk(inner, innerScope)
+			inner.setPath(path)
+		}
+	}
+	walk(root.rootFrame, root.rootFrame.Path())
+}
+
+// frameName returns the name of a frame from the path built by GetInnerName, without parents and index
+func frameName(path string) string {
+	name := path[strings.LastIndex(path, ".")+1:]
+	if index := strings.LastIndex(name, "_"); index > 0 {
+		return name[:index]
+	}
+	return name
+}
+
+// fingerprint hashes tokens of source code, so it's not changed by formatting and comments
+func fingerprint(source []byte) string {
+	fSet := token.NewFileSet()
+	file := fSet.AddFile("", fSet.Base(), len(source))
+	var s scanner.Scanner
+	s.Init(file, source, nil, 0)
+	h := fnv.New32a()
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.SEMICOLON && lit == "\n" { // inserted by line break
+			continue
+		}
+		_, _ = fmt.Fprintf(h, "%d %s\n", tok, lit)
+	}
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// SplitStablePath splits a stable path into the scope, the name of frame and the fingerprint,
+// ok is false for paths of declared functions, which have no fingerprint
+func SplitStablePath(path string) (scope, name, fingerprint string, ok bool) {
+	at := strings.LastIndex(path, "@")
+	if at < 0 {
+		return "", "", "", false
+	}
+	dot := strings.LastIndex(path[:at], ".")
+	if dot < 0 {
+		return "", "", "", false
+	}
+	fingerprint = path[at+1:]
+	if hash := strings.Index(fingerprint, "#"); hash >= 0 {
+		fingerprint = fingerprint[:hash]
+	}
+	return path[:dot], path[dot+1 : at], fingerprint, true
+}
@@ -6,6 +6,9 @@ type Stats struct {
 	CaseAmount     int // include select, switch and typed switch
 	FuncAmount     int
 	GoFuncAmount   int
+	RecoverAmount  int
+	DeferAmount    int
+	CondAmount     int // number of operands in conditions
 	InjectionPoint int // number of point to inject track code
 	Lines          int // number of lines of code
 }
@@ -16,6 +19,9 @@ func (s *Stats) Add(b Stats) {
 	s.CaseAmount += b.CaseAmount
 	s.FuncAmount += b.FuncAmount
 	s.GoFuncAmount += b.GoFuncAmount
+	s.RecoverAmount += b.RecoverAmount
+	s.DeferAmount += b.DeferAmount
+	s.CondAmount += b.CondAmount
 	s.InjectionPoint += b.InjectionPoint
 	s.Lines += b.Lines
 }
@@ -0,0 +1,100 @@
+package frame
+
+// TypeSwitchFrame is the guard of a type switch, cases of it record the dynamic type matched
+// by the symbol of the guard, a symbol is bound if there is none and any case records it:
+//
+//	switch x.(type) { case error: ... } => switch _t1 := x.(type) { case error: _g_sdk.Type(g, e, _t1); ... }
+//
+// the symbol has the dynamic type of the guard in cases with multiple types and default,
+// and it's the matched type in cases of a single type. The guard is unchanged without cases,
+// such as `switch x.(type) {}`, as the symbol bound would be unused
+type TypeSwitchFrame struct {
+	*baseFrame
+	symbol     string
+	bindOffset int  // beginning of the guard without symbol
+	captured   bool // any case records the type matched
+}
+
+// NewTypeSwitchFrame creates frame for the guard, symbol is empty if the guard has none,
+// then it's bound at bindOffset
+func NewTypeSwitchFrame(path string, symbol string, bindOffset int) *TypeSwitchFrame {
+	return &TypeSwitchFrame{baseFrame: NewBaseFrame(path), symbol: symbol, bindOffset: bindOffset}
+}
+
+func (frame *TypeSwitchFrame) Kind() string {
+	return KindTypeSwitch
+}
+
+func (frame *TypeSwitchFrame) edits() []edit {
+	if frame.symbol != "" || !frame.captured {
+		return nil
+	}
+	return []edit{{offset: frame.bindOffset, end: frame.bindOffset, gen: func(genEnv *baseEnv) []byte {
+		frame.symbol = genEnv.genTempVarName()
+		return []byte(frame.symbol + " := ")
+	}}}
+}
+
+func (frame *TypeSwitchFrame) GenBeginning(genEnv *baseEnv) []byte {
+	return nil
+}
+
+func (frame *TypeSwitchFrame) GenEnding(genEnv *baseEnv) []byte {
+	return nil
+}
+
+func (frame *TypeSwitchFrame) GenEnv(genEnv *baseEnv) []byte {
+	return nil
+}
+
+// genCapture records the type matched at the beginning of a case
+func (frame *TypeSwitchFrame) genCapture(genEnv *baseEnv, varName string) string {
+	return genSDKFunCallWithArgs("Type", genEnv.GetCurrentGoIDVarName(), varName, frame.symbol)
+}
+
+// SelectFrame wraps a select statement in a block with the time it starts,
+// cases of it record the time blocked before the case is chosen:
+//
+//	{var _t1 = _g_sdk.Now(); select { case v := <-ch: _g_sdk.Select(g, e, _t1); ... }}
+//
+// a labeled select is wrapped with its label, as break of the label must be in the select
+type SelectFrame struct {
+	*baseFrame
+	stmtOffset int // beginning of the select statement, including its label
+	stmtEnd    int
+	startVar   string
+}
+
+func NewSelectFrame(path string, stmtOffset, stmtEnd int) *SelectFrame {
+	return &SelectFrame{baseFrame: NewBaseFrame(path), stmtOffset: stmtOffset, stmtEnd: stmtEnd}
+}
+
+func (frame *SelectFrame) Kind() string {
+	return KindSelect
+}
+
+// edits closes the wrapped block before other edits at the end, such as the ending of parent frame
+func (frame *SelectFrame) edits() []edit {
+	return []edit{
+		{offset: frame.stmtOffset, end: frame.stmtOffset, gen: frame.GenBeginning},
+		{offset: frame.stmtEnd, end: frame.stmtEnd, gen: frame.GenEnding, first: true},
+	}
+}
+
+func (frame *SelectFrame) GenBeginning(genEnv *baseEnv) []byte {
+	frame.startVar = genEnv.genTempVarName()
+	return []byte("{var " + frame.startVar + " = " + SDKPackagePrefix + "Now();")
+}
+
+func (frame *SelectFrame) GenEnding(genEnv *baseEnv) []byte {
+	return []byte("}")
+}
+
+func (frame *SelectFrame) GenEnv(genEnv *baseEnv) []byte {
+	return nil
+}
+
+// genCapture records the time blocked at the beginning of a case
+func (frame *SelectFrame) genCapture(genEnv *baseEnv, varName string) string {
+	return genSDKFunCallWithArgs("Select", genEnv.GetCurrentGoIDVarName(), varName, frame.startVar)
+}
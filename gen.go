@@ -2,22 +2,27 @@ package main
 
 import (
 	"bytes"
-	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/Unixeno/gootprint/frame"
 	log "github.com/sirupsen/logrus"
 )
 
 type Generator struct {
-	sourceFilename string
-	sourceContent  []byte
-	outputFilename string
-	outputFile     *os.File
-	contextFrame   *frame.Context
+	sourceFilename   string
+	sourceContent    []byte
+	outputFilename   string
+	manifestFilename string
+	outputFile       *os.File
+	contextFrame     *frame.Context
+	remapFrom        *frame.Manifest // manifest of an earlier generation, nil if there is none
 }
 
-func NewGenerator(source string, contextFrame *frame.Context) *Generator {
+// NewGenerator creates a generator writes instrumented source to output,
+// the manifest is written next to the output file
+func NewGenerator(source, output string, contextFrame *frame.Context) *Generator {
 	content, err := os.ReadFile(source)
 	if err != nil {
 		log.WithError(err).Fatalf("failed to read source file")
@@ -27,44 +32,69 @@ func NewGenerator(source string, contextFrame *frame.Context) *Generator {
 		log.WithError(err).Fatalf("failed to stat source file")
 	}
 
-	// detect `\r\n` line break
-	if bytes.Contains(content, []byte("\r\n")) {
-		log.Fatal("found `\\r\\n` in source file, this will break code generation")
-	}
-
-	outputFilename := fmt.Sprintf("%s.gen.go", source)
+	outputFilename := output
 	log.Infof("output file is %s", outputFilename)
+	if err := os.MkdirAll(filepath.Dir(outputFilename), 0755); err != nil {
+		log.WithError(err).Fatal("failed to create output directory")
+	}
 	fd, err := os.OpenFile(outputFilename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, sourceFileInfo.Mode())
 	if err != nil {
 		log.WithError(err).Fatal("failed to open file for writing")
 	}
 	generator := Generator{
-		sourceFilename: source,
-		sourceContent:  content,
-		outputFilename: outputFilename,
-		outputFile:     fd,
-		contextFrame:   contextFrame,
+		sourceFilename:   source,
+		sourceContent:    content,
+		outputFilename:   outputFilename,
+		manifestFilename: strings.TrimSuffix(outputFilename, ".go") + ".json",
+		outputFile:       fd,
+		contextFrame:     contextFrame,
 	}
 	return &generator
 }
 
 var newLine = []byte("\n")
 
+// SetRemapFrom sets the manifest of an earlier generation, which is compared with paths generated
+func (g *Generator) SetRemapFrom(old *frame.Manifest) {
+	g.remapFrom = old
+}
+
 func (g *Generator) Generate() {
 	g.contextFrame.PrepareGenerate()
-	lines := bytes.Split(g.sourceContent, []byte("\n"))
-	for lineNumber, line := range lines {
-		g.outputFile.Write(g.contextFrame.GenerateLine(lineNumber+1, line))
-		if lineNumber < len(lines)-1 {
-			g.outputFile.Write(newLine)
-		} else {
-			// last line, if source file doesn't end with a new line, we need to add one
-			if len(line) != 0 {
-				_, _ = g.outputFile.Write(newLine)
-			}
-		}
+	content := g.contextFrame.Generate(g.sourceContent)
+	g.outputFile.Write(content)
+	// if source file doesn't end with a new line, we need to add one
+	if !bytes.HasSuffix(content, newLine) {
+		g.outputFile.Write(newLine)
 	}
 	g.outputFile.Write(g.contextFrame.GenerateEnv())
+	if err := g.outputFile.Close(); err != nil {
+		log.WithError(err).Fatalf("failed to write %s", g.outputFilename)
+	}
+	if g.contextFrame.Options().StablePaths && g.remapFrom != nil {
+		g.writeRemap()
+	}
+	if err := g.contextFrame.Manifest().WriteFile(g.manifestFilename); err != nil {
+		log.WithError(err).Fatalf("failed to write manifest %s", g.manifestFilename)
+	}
+}
+
+// writeRemap compares the manifest with the one of an earlier generation, a remap file is written
+// next to the manifest if paths are changed, a stale one is removed otherwise
+func (g *Generator) writeRemap() {
+	remap := g.contextFrame.Manifest().RemapFrom(g.remapFrom)
+	remapFilename := strings.TrimSuffix(g.manifestFilename, ".json") + ".remap.json"
+	if remap.Empty() {
+		if err := os.Remove(remapFilename); err != nil && !os.IsNotExist(err) {
+			log.WithError(err).Warnf("failed to remove stale remap %s", remapFilename)
+		}
+		return
+	}
+	if err := remap.WriteFile(remapFilename); err != nil {
+		log.WithError(err).Fatalf("failed to write remap %s", remapFilename)
+	}
+	log.Infof("%d frame paths changed, %d removed and %d added, remap file: %s",
+		len(remap.Changed), len(remap.Removed), len(remap.Added), remapFilename)
 }
 
 func (g *Generator) RenameSource() {
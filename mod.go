@@ -3,13 +3,31 @@ package main
 import (
 	"os"
 	"path"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
 )
 
 var PackageRoot string
 
+// GoVersion is the language version declared by the go mod file, such as `1.23`, it's empty if not declared
+var GoVersion string
+
+// rangeFuncVersion is the first language version supports range over function iterators
+const rangeFuncVersion = "1.23"
+
+// supportRangeFunc reports whether a file can range over function iterators, by its language version.
+// fileVersion is the version of its build constraint, such as `go1.23` of `//go:build go1.23`, which overrides the go mod file
+func supportRangeFunc(fileVersion string) bool {
+	version := GoVersion
+	if fileVersion != "" {
+		version = strings.TrimPrefix(fileVersion, "go")
+	}
+	return version != "" && semver.Compare("v"+version, "v"+rangeFuncVersion) >= 0
+}
+
 func try(filename string) bool {
 	content, err := os.ReadFile(filename)
 	if os.IsNotExist(err) {
@@ -24,6 +42,9 @@ func try(filename string) bool {
 	log.Infof("found module %s", module.Module.Mod.Path)
 	log.Infof("module path: %s", path.Dir(filename))
 	PackageRoot = path.Dir(filename)
+	if module.Go != nil {
+		GoVersion = module.Go.Version
+	}
 	return true
 }
 
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"encoding/json"
+	"go/build"
 	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 
 	"github.com/Unixeno/gootprint/frame"
 	log "github.com/sirupsen/logrus"
@@ -20,16 +23,106 @@ const (
 var FileCounter int
 
 type Processor struct {
-	name  string
-	mode  int
-	stats frame.Stats
+	name       string
+	mode       int
+	stats      frame.Stats
+	overlayDir string            // write instrumented files into overlayDir instead of the source tree
+	overlay    map[string]string // source file => instrumented file
+	options    frame.Options
+	checker    *typeChecker      // packages are type checked if it's set
+	testMain   bool              // hook TestMain of packages with tests, only in overlay mode
+	remapFrom  revision          // manifests of an earlier generation, paths changed are written to remap files
+	fileHashes map[uint64]string // file hash => source file processed, point ids of files must not collide
+	stopped    int32             // set by Stop, remaining files are skipped
+}
+
+// overlayFile is the format of file used by `go build -overlay`
+type overlayFile struct {
+	Replace map[string]string
 }
 
 func NewProcessor(name string, mode int) *Processor {
 	return &Processor{
-		name: name,
-		mode: mode,
+		name:       name,
+		mode:       mode,
+		fileHashes: map[uint64]string{},
+	}
+}
+
+func (p *Processor) SetOptions(options frame.Options) {
+	p.options = options
+}
+
+// SetTypeCheck enables type checked mode, files are parsed and checked with their packages,
+// and instrumented with type information
+func (p *Processor) SetTypeCheck(enabled bool) {
+	if enabled {
+		p.checker = newTypeChecker()
+	} else {
+		p.checker = nil
+	}
+}
+
+// SetTestMain makes test binaries flush the sdk when tests finish, as main.main is not run by tests,
+// it only works in overlay mode
+func (p *Processor) SetTestMain(enabled bool) {
+	p.testMain = enabled
+}
+
+// SetRemapFrom loads manifests of an earlier generation from a directory or a manifest file,
+// paths of files generated with stable paths are compared with them. Manifests are loaded before generating,
+// so it can be the output location of this generation
+func (p *Processor) SetRemapFrom(name string) {
+	manifests, err := loadManifests(name)
+	if err != nil {
+		log.WithError(err).Fatalf("failed to load manifests from %s", name)
+	}
+	p.remapFrom = manifests
+}
+
+// Stop makes the processor skip files not processed yet, it can be called by another goroutine,
+// such as on interrupt, files generated are kept
+func (p *Processor) Stop() {
+	atomic.StoreInt32(&p.stopped, 1)
+}
+
+// Stopped reports whether Stop is called
+func (p *Processor) Stopped() bool {
+	return atomic.LoadInt32(&p.stopped) != 0
+}
+
+// SetOverlay enables overlay mode, instrumented files are written into dir,
+// source files are never touched, and an overlay file is written for `go build -overlay`
+func (p *Processor) SetOverlay(dir string) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		log.WithError(err).Fatalf("invalid overlay directory: %s", dir)
+	}
+	p.overlayDir = absDir
+	p.overlay = map[string]string{}
+}
+
+// OverlayFilename returns the overlay file for `go build -overlay`, only valid in overlay mode
+func (p *Processor) OverlayFilename() string {
+	return filepath.Join(p.overlayDir, "overlay.json")
+}
+
+func (p *Processor) outputFilename(absFilename string) string {
+	if p.overlayDir != "" {
+		return filepath.Join(p.overlayDir, absFilename+".gen.go")
 	}
+	return absFilename + ".gen.go"
+}
+
+func (p *Processor) writeOverlay() {
+	content, err := json.MarshalIndent(overlayFile{Replace: p.overlay}, "", "  ")
+	if err != nil {
+		log.WithError(err).Fatal("failed to encode overlay")
+	}
+	if err = os.WriteFile(p.OverlayFilename(), content, 0644); err != nil {
+		log.WithError(err).Fatal("failed to write overlay file")
+	}
+	log.Infof("overlay file: %s", p.OverlayFilename())
 }
 
 func (p *Processor) processFile(filename string) {
@@ -40,7 +133,13 @@ func (p *Processor) processFile(filename string) {
 		absFilename = filename
 	}
 	log.Infof("parsering file: %s", absFilename)
-	parser := NewParser(absFilename)
+	p.checkFileHash(absFilename)
+	var parser *Parser
+	if pkg := p.typedPackage(absFilename); pkg != nil {
+		parser = NewTypedParser(absFilename, fileKey(absFilename), p.options, pkg)
+	} else {
+		parser = NewParser(absFilename, fileKey(absFilename), p.options)
+	}
 	parser.Parse()
 	parser.FrameContext().PostOrderDump()
 	p.stats.Add(parser.FrameContext().Stats())
@@ -48,22 +147,49 @@ func (p *Processor) processFile(filename string) {
 		return
 	}
 	log.Info("start generating...")
-	generator := NewGenerator(absFilename, parser.FrameContext())
-	_ = generator
+	outputFilename := p.outputFilename(absFilename)
+	generator := NewGenerator(absFilename, outputFilename, parser.FrameContext())
+	if p.remapFrom != nil {
+		generator.SetRemapFrom(p.remapFrom[fileKey(absFilename)])
+	}
 	generator.Generate()
+	if p.overlayDir != "" {
+		p.overlay[absFilename] = outputFilename
+		return
+	}
 	if !*noRename {
 		log.Infof("replace file: %s", filename)
 		generator.RenameSource()
 	}
 }
 
+// checkFileHash fails if point ids of a file collide with a file processed before,
+// then files must be renamed, otherwise their points are merged in traces
+func (p *Processor) checkFileHash(absFilename string) {
+	key := fileKey(absFilename)
+	hash := frame.FileHash(key)
+	if other, ok := p.fileHashes[hash]; ok && other != absFilename {
+		log.Fatalf("point ids of `%s` collide with `%s`, file hash of `%s` is %#x", absFilename, other, key, hash)
+	}
+	p.fileHashes[hash] = absFilename
+}
+
+// typedPackage returns the type checked package of a file, it's nil if type checked mode is disabled
+// or the file is not checked
+func (p *Processor) typedPackage(absFilename string) *typedPackage {
+	if p.checker == nil {
+		return nil
+	}
+	return p.checker.Package(absFilename)
+}
+
 func (p *Processor) processDir(dirname string) {
 	files, err := os.ReadDir(dirname)
 	if err != nil {
 		log.Fatalf("failed to read directory: %v", err)
 	}
 	for _, file := range files {
-		if file.IsDir() {
+		if file.IsDir() || p.Stopped() {
 			continue
 		}
 		filename := file.Name()
@@ -91,6 +217,9 @@ func (p *Processor) processPackage(packagePath string) {
 			return nil
 		}
 		if d.IsDir() {
+			if p.overlayDir != "" && isSameDir(filePath, p.overlayDir) {
+				return fs.SkipDir
+			}
 			return nil
 		}
 		if strings.HasPrefix(d.Name(), ".") {
@@ -106,9 +235,27 @@ func (p *Processor) processPackage(packagePath string) {
 		files = append(files, filePath)
 		return nil
 	})
+	// packages are checked before generating, as a package may be imported by packages after it
+	for _, filename := range files {
+		if absFilename, err := filepath.Abs(filename); err == nil && !p.Stopped() {
+			p.typedPackage(absFilename)
+		}
+	}
 	for _, filename := range files {
+		if p.Stopped() {
+			return
+		}
 		p.processFile(filename)
 	}
+	if p.testMain && p.overlayDir != "" && !*dryRun {
+		dirs := map[string]bool{}
+		for _, filename := range files {
+			if absFilename, err := filepath.Abs(filename); err == nil && !dirs[filepath.Dir(absFilename)] {
+				dirs[filepath.Dir(absFilename)] = true
+				p.hookTestMain(filepath.Dir(absFilename))
+			}
+		}
+	}
 }
 
 func (p *Processor) Stats() frame.Stats {
@@ -124,6 +271,9 @@ func (p *Processor) Process() {
 	case ModePackage:
 		p.processPackage(p.name)
 	}
+	if p.overlayDir != "" && !*dryRun {
+		p.writeOverlay()
+	}
 }
 
 func (p *Processor) ProcessClean() {
@@ -140,6 +290,8 @@ func (p *Processor) ProcessClean() {
 func (p *Processor) cleanFile(filePath string) {
 	log.Debugf("clean for `%s`", filePath)
 	generated := filePath + ".gen.go"
+	manifest := filePath + ".gen.json"
+	remap := filePath + ".gen.remap.json"
 	backup := filePath + ".gen_bak"
 	// try to delete gen.go file
 	info, err := os.Stat(generated)
@@ -156,6 +308,14 @@ func (p *Processor) cleanFile(filePath string) {
 			log.WithError(err).Fatalf("can't delete file: %s", generated)
 		}
 	}
+	err = os.Remove(manifest)
+	if err != nil && !os.IsNotExist(err) {
+		log.WithError(err).Fatalf("can't delete file: %s", manifest)
+	}
+	err = os.Remove(remap)
+	if err != nil && !os.IsNotExist(err) {
+		log.WithError(err).Fatalf("can't delete file: %s", remap)
+	}
 	// try to recover backup
 	info, err = os.Stat(backup)
 	if err != nil {
@@ -225,6 +385,31 @@ func (p *Processor) cleanPackage(packagePath string) {
 	}
 }
 
+// fileKey returns the stable name of a source file, which is the path relative to the module root,
+// or to the source directory of GOPATH, point ids are built from it, so they don't depend on where
+// the source is checked out. It fails if the file is in neither
+func fileKey(absFilename string) string {
+	if PackageRoot != "" {
+		if root, err := filepath.Abs(PackageRoot); err == nil {
+			if rel, err := filepath.Rel(root, absFilename); err == nil && !strings.HasPrefix(rel, "..") {
+				return filepath.ToSlash(rel)
+			}
+		}
+	}
+	for _, gopath := range filepath.SplitList(build.Default.GOPATH) {
+		if rel, err := filepath.Rel(filepath.Join(gopath, "src"), absFilename); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	log.Fatalf("`%s` is neither in a module nor in GOPATH, can't build stable point ids", absFilename)
+	return ""
+}
+
+func isSameDir(dir, absDir string) bool {
+	abs, err := filepath.Abs(dir)
+	return err == nil && abs == absDir
+}
+
 func pathFilter(filePath string, rules []string) bool {
 	for _, rule := range rules {
 		if filePath == rule {
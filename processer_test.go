@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Unixeno/gootprint/frame"
+)
+
+const stableSample = `package sample
+
+func Abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+`
+
+// generateManifest instruments sample/abs.go of a module checked out in dir
+func generateManifest(t *testing.T, dir string) *frame.Manifest {
+	t.Helper()
+	module := filepath.Join(dir, "sample")
+	if err := os.MkdirAll(module, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/m\n\ngo 1.16\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(module, "abs.go")
+	if err := os.WriteFile(filename, []byte(stableSample), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !LocateModule(dir) {
+		t.Fatalf("module is not found in %s", dir)
+	}
+	p := NewParser(filename, fileKey(filename), frame.Options{})
+	p.Parse()
+	context := p.FrameContext()
+	context.PrepareGenerate()
+	context.Generate(p.source)
+	context.GenerateEnv()
+	return context.Manifest()
+}
+
+// TestPointIDsAreStable generates the same module checked out at two paths, point ids must be the same
+func TestPointIDsAreStable(t *testing.T) {
+	root, version := PackageRoot, GoVersion
+	defer func() {
+		PackageRoot, GoVersion = root, version
+	}()
+
+	first := generateManifest(t, t.TempDir())
+	second := generateManifest(t, t.TempDir())
+	if first.File != "sample/abs.go" {
+		t.Errorf("file key is %s, want the path relative to the module root", first.File)
+	}
+	if len(first.Points) == 0 {
+		t.Fatal("no points generated")
+	}
+	ids := func(m *frame.Manifest) []uint64 {
+		var ids []uint64
+		for _, point := range m.Points {
+			ids = append(ids, point.ID)
+		}
+		return ids
+	}
+	if !reflect.DeepEqual(ids(first), ids(second)) {
+		t.Errorf("point ids differ between runs: %#x, %#x", ids(first), ids(second))
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Unixeno/gootprint/frame"
+	"github.com/Unixeno/gootprint/trace"
+	log "github.com/sirupsen/logrus"
+)
+
+// revision is the manifests of a revision of source code, by file keys
+type revision map[string]*frame.Manifest
+
+// runRemap matches frames of an old revision with a new one, and reports frames changed, deleted and new.
+// A revision is a source tree, which is parsed with stable paths, or a manifest written by generation.
+// Frames are matched by stable paths, and lines not changed by a diff if it's given,
+// a trace recorded by the old revision can be translated, events of deleted frames are dropped
+func runRemap(args []string) int {
+	flags := flag.NewFlagSet("remap", flag.ExitOnError)
+	diffFile := flags.String("diff", "", "unified diff `file` from old to new, required for manifests without stable paths")
+	traceFile := flags.String("trace", "", "translate trace or counter `file` recorded by the old revision to the new one")
+	output := flags.String("out", "", "output `file` of the translated trace, default is the trace file with .remap suffix")
+	instrument := registerInstrumentFlags(flags)
+	registerLogFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s remap [flags] <old> <new>\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "old and new are source directories or manifest files, source directories are "+
+			"instrumented in memory with the instrumentation flags, which must be the same as the traced binary")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args) // exit on error
+	setLogLevel()
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	options := instrument.options()
+	oldRevision, err := loadRevision(flags.Arg(0), options, *instrument.typeCheck)
+	if err != nil {
+		log.WithError(err).Errorf("failed to load old revision %s", flags.Arg(0))
+		return 1
+	}
+	newRevision, err := loadRevision(flags.Arg(1), options, *instrument.typeCheck)
+	if err != nil {
+		log.WithError(err).Errorf("failed to load new revision %s", flags.Arg(1))
+		return 1
+	}
+	var diffs []*fileDiff
+	if *diffFile != "" {
+		f, err := os.Open(*diffFile)
+		if err != nil {
+			log.WithError(err).Error("failed to open diff file")
+			return 1
+		}
+		diffs, err = parseDiff(f)
+		_ = f.Close()
+		if err != nil {
+			log.WithError(err).Error("failed to parse diff file")
+			return 1
+		}
+	}
+
+	remaps, ids := remapRevision(oldRevision, newRevision, diffs)
+	printRemaps(remaps)
+	if *traceFile == "" {
+		return 0
+	}
+	if *output == "" {
+		*output = *traceFile + ".remap"
+	}
+	translated, dropped, err := translateTrace(*traceFile, *output, newRevision, ids)
+	if err != nil {
+		log.WithError(err).Error("failed to translate trace")
+		return 1
+	}
+	fmt.Printf("events translated: %d, dropped: %d, trace file: %s\n", translated, dropped, *output)
+	return 0
+}
+
+// loadRevision loads a manifest file, or instruments a source directory in memory with options,
+// paths are always stable for source directories
+func loadRevision(name string, options frame.Options, typeCheck bool) (revision, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return loadManifests(name)
+	}
+
+	absDir, err := filepath.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+	if !LocateModule(absDir) { // file keys are relative to the module root
+		return nil, fmt.Errorf("cannot find go mod file in `%s` and it's upper directory", absDir)
+	}
+	options.StablePaths = true
+	var checker *typeChecker
+	if typeCheck {
+		checker = newTypeChecker()
+	}
+	manifests := revision{}
+	err = filepath.WalkDir(absDir, func(filename string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if filename != absDir && (d.Name() == "vendor" || d.Name() == "testdata" ||
+				strings.HasPrefix(d.Name(), ".") || strings.HasPrefix(d.Name(), "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(filename, ".go") ||
+			strings.HasSuffix(filename, "_test.go") ||
+			strings.HasSuffix(filename, ".gen.go") {
+			return nil
+		}
+		var parser *Parser
+		var pkg *typedPackage
+		if checker != nil {
+			pkg = checker.Package(filename)
+		}
+		if pkg != nil {
+			parser = NewTypedParser(filename, fileKey(filename), options, pkg)
+		} else {
+			parser = NewParser(filename, fileKey(filename), options)
+		}
+		parser.Parse()
+		context := parser.FrameContext()
+		context.PrepareGenerate()
+		context.Generate(parser.source)
+		context.GenerateEnv()
+		manifests[fileKey(filename)] = context.Manifest()
+		return nil
+	})
+	return manifests, err
+}
+
+// loadManifests loads a manifest file, or manifests written by generation in a directory,
+// such as a source tree or an overlay directory kept after generation
+func loadManifests(name string) (revision, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		m, err := frame.LoadManifest(name)
+		if err != nil {
+			return nil, err
+		}
+		return revision{m.File: m}, nil
+	}
+	manifests := revision{}
+	err = filepath.WalkDir(name, func(filename string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(filename, ".gen.json") {
+			return err
+		}
+		m, err := frame.LoadManifest(filename)
+		if err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+		manifests[m.File] = m
+		return nil
+	})
+	return manifests, err
+}
+
+// remapRevision remaps manifests of the same file, files are renamed by the diff,
+// frames of files only in one revision are all deleted or new. It returns remaps by file keys of new revision,
+// or old revision for deleted files, and point ids mapped from old revision to the new one
+func remapRevision(oldRevision, newRevision revision, diffs []*fileDiff) (map[string]*frame.Remap, map[uint64]uint64) {
+	remaps := map[string]*frame.Remap{}
+	ids := map[uint64]uint64{}
+	matched := map[string]bool{}
+	for key, old := range oldRevision {
+		newKey, lines := key, identityLines
+		if diffs == nil {
+			lines = nil
+		}
+		for _, d := range diffs {
+			if sameFile(d.oldName, key) {
+				newKey, lines = "", d.Line
+				for candidate := range newRevision {
+					if sameFile(d.newName, candidate) {
+						newKey = candidate
+					}
+				}
+				break
+			}
+		}
+		m, ok := newRevision[newKey]
+		if !ok {
+			remaps[key] = (&frame.Manifest{File: key}).RemapFrom(old)
+			continue
+		}
+		matched[newKey] = true
+		remaps[newKey] = m.RemapWithLines(old, lines)
+		for oldID, id := range m.PointsFrom(old, remaps[newKey]) {
+			ids[oldID] = id
+		}
+	}
+	for key, m := range newRevision {
+		if !matched[key] {
+			remaps[key] = m.RemapFrom(&frame.Manifest{})
+		}
+	}
+	return remaps, ids
+}
+
+// identityLines maps lines of files not changed by the diff
+func identityLines(line int) (int, bool) {
+	return line, true
+}
+
+func printRemaps(remaps map[string]*frame.Remap) {
+	keys := make([]string, 0, len(remaps))
+	for key := range remaps {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	changed, deleted, added := 0, 0, 0
+	for _, key := range keys {
+		remap := remaps[key]
+		if remap.Empty() {
+			continue
+		}
+		fmt.Fprintf(w, "%s\n", key)
+		for _, change := range remap.Changed {
+			fmt.Fprintf(w, "    changed\t%s\t%s\n", change.Old, change.New)
+		}
+		for _, path := range remap.Removed {
+			fmt.Fprintf(w, "    deleted\t%s\t\n", path)
+		}
+		for _, path := range remap.Added {
+			fmt.Fprintf(w, "    new\t%s\t\n", path)
+		}
+		changed += len(remap.Changed)
+		deleted += len(remap.Removed)
+		added += len(remap.Added)
+	}
+	fmt.Fprintf(w, "frames changed: %d, deleted: %d, new: %d\n", changed, deleted, added)
+	_ = w.Flush()
+}
+
+// translateTrace rewrites a trace of the old revision with points of the new revision,
+// the header registers all points of the new revision, events of points not mapped are dropped
+func translateTrace(input, output string, newRevision revision, ids map[uint64]uint64) (translated, dropped int, err error) {
+	in, err := os.Open(input)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer in.Close()
+	reader, err := trace.NewReader(in)
+	if err != nil {
+		return 0, 0, err
+	}
+	out, err := os.Create(output)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer out.Close()
+
+	keys := make([]string, 0, len(newRevision))
+	for key := range newRevision {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var files []trace.File
+	var points []trace.Point
+	for _, key := range keys {
+		file := trace.File{ID: uint32(len(files)), Name: newRevision[key].Source}
+		files = append(files, file)
+		for _, point := range newRevision[key].Points {
+			points = append(points, trace.Point{ID: point.ID, File: file.ID, Path: point.TracePath()})
+		}
+	}
+	writer := trace.NewWriter(out)
+	writer.WriteHeader(files, points)
+	for {
+		event, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return translated, dropped, err
+		}
+		switch event.(type) {
+		case trace.File, trace.Point: // replaced by the header
+			continue
+		}
+		event, ok := translatePoint(event, ids)
+		if !ok {
+			dropped++
+			continue
+		}
+		writer.Write(event)
+		translated++
+	}
+	if err = writer.Flush(); err != nil {
+		return translated, dropped, err
+	}
+	return translated, dropped, out.Close()
+}
+
+// translatePoint replaces the point of an event, it's false if the point is not mapped
+func translatePoint(event trace.Event, ids map[uint64]uint64) (trace.Event, bool) {
+	var ok bool
+	switch e := event.(type) {
+	case trace.Collect:
+		e.Point, ok = ids[e.Point]
+		return e, ok
+	case trace.Call:
+		e.Point, ok = ids[e.Point]
+		return e, ok
+	case trace.Exit:
+		e.Point, ok = ids[e.Point]
+		return e, ok
+	case trace.Panic:
+		e.Point, ok = ids[e.Point]
+		return e, ok
+	case trace.Recover:
+		e.Point, ok = ids[e.Point]
+		return e, ok
+	case trace.Defer:
+		e.Point, ok = ids[e.Point]
+		return e, ok
+	case trace.Init:
+		e.Point, ok = ids[e.Point]
+		return e, ok
+	case trace.Cond:
+		e.Point, ok = ids[e.Point]
+		return e, ok
+	case trace.Loop:
+		e.Point, ok = ids[e.Point]
+		return e, ok
+	case trace.Match:
+		e.Point, ok = ids[e.Point]
+		return e, ok
+	case trace.Select:
+		e.Point, ok = ids[e.Point]
+		return e, ok
+	case trace.Count:
+		e.Point, ok = ids[e.Point]
+		return e, ok
+	}
+	return event, true
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/Unixeno/gootprint/frame"
+	"github.com/Unixeno/gootprint/trace"
+	log "github.com/sirupsen/logrus"
+)
+
+// subcommands working on trace files, they never touch source code
+var traceSubcommands = map[string]func(args []string) int{
+	"conditions": runConditionReport,
+	"remap":      runRemap,
+}
+
+// operandCoverage is the values observed for an operand of condition
+type operandCoverage struct {
+	operand   string
+	seenTrue  bool
+	seenFalse bool
+}
+
+func (c *operandCoverage) covered() bool {
+	return c.seenTrue && c.seenFalse
+}
+
+// runConditionReport prints operands of every condition recorded in condition coverage mode,
+// with the values observed, an operand is covered when it was both true and false
+func runConditionReport(args []string) int {
+	flags := flag.NewFlagSet("conditions", flag.ExitOnError)
+	uncovered := flags.Bool("uncovered", false, "only show conditions with operands not covered")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s conditions [flags] <trace file>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args) // exit on error
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.WithError(err).Error("failed to open trace file")
+		return 1
+	}
+	defer f.Close()
+	reader, err := trace.NewReader(f)
+	if err != nil {
+		log.WithError(err).Error("failed to read trace file")
+		return 1
+	}
+	values := map[uint64]*operandCoverage{}
+	for {
+		event, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			log.WithError(err).Error("failed to read trace file")
+			return 1
+		}
+		if cond, ok := event.(trace.Cond); ok {
+			c, ok := values[cond.Point]
+			if !ok {
+				c = &operandCoverage{}
+				values[cond.Point] = c
+			}
+			if cond.Value {
+				c.seenTrue = true
+			} else {
+				c.seenFalse = true
+			}
+		}
+	}
+
+	// points of a condition are registered in order, so operands are grouped by the decision
+	var decisions []string
+	operands := map[string][]*operandCoverage{}
+	for _, point := range reader.Points() {
+		decision, operand, ok := frame.SplitConditionPath(point.Path)
+		if !ok {
+			continue
+		}
+		if file, ok := reader.File(point.File); ok {
+			decision = file.Name + " " + decision
+		}
+		c, ok := values[point.ID]
+		if !ok {
+			c = &operandCoverage{}
+		}
+		c.operand = operand
+		if _, ok := operands[decision]; !ok {
+			decisions = append(decisions, decision)
+		}
+		operands[decision] = append(operands[decision], c)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	total, covered := 0, 0
+	for _, decision := range decisions {
+		amount := 0
+		for _, c := range operands[decision] {
+			if c.covered() {
+				amount++
+			}
+		}
+		total += len(operands[decision])
+		covered += amount
+		if *uncovered && amount == len(operands[decision]) {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d/%d\n", decision, amount, len(operands[decision]))
+		for _, c := range operands[decision] {
+			fmt.Fprintf(w, "    %s\t%s\t%s\t%s\n", c.operand, observed(c.seenTrue, "true"), observed(c.seenFalse, "false"), coveredText(c.covered()))
+		}
+	}
+	fmt.Fprintf(w, "operands covered: %d/%d\n", covered, total)
+	_ = w.Flush()
+	return 0
+}
+
+func observed(seen bool, value string) string {
+	if seen {
+		return value
+	}
+	return "-"
+}
+
+func coveredText(covered bool) string {
+	if covered {
+		return "covered"
+	}
+	return "not covered"
+}
@@ -1,32 +1,121 @@
 package sdk
 
 import (
-	"fmt"
+	"time"
+
 	"github.com/silentred/gid"
-	"sync/atomic"
 )
 
-var eventCounter uint32
-
-func NewE(filename, path string) uint16 {
-	eventID := uint16(atomic.AddUint32(&eventCounter, 1))
-	fmt.Printf("register event %d for %s\n", eventID, path)
-	return eventID
+// NewE registers a point, the id is assigned by the generator and is unique in a program.
+// It returns the value passed to collecting functions, which is the id, or the index of its counter in counter mode
+func NewE(filename string, id uint64, path string) uint64 {
+	return registerPoint(id, filename, path)
 }
 
 func RegisterFile(filename string) struct{} {
-	fmt.Println("register file: ", filename)
+	registerFile(filename)
 	return struct{}{}
 }
 
-func C(id int64, x uint16) {
-	fmt.Printf("collect event: [%d] %d\n", id, x)
+// C collects an event, it's lock-free and never allocates, it only increments the counter of point in counter mode
+func C(id int64, x uint64) {
+	push(kindCollect, id, x, 0)
 }
 
-func Call(_ uint16) int64 {
+// Call records a function call and returns the current goroutine id, it's always 0 in counter mode
+func Call(x uint64) int64 {
+	if counterMode {
+		count(x)
+		return 0
+	}
+	id := gid.Get()
+	push(kindCall, id, x, 0)
+	return id
+}
+
+// Goroutine returns the current goroutine id, it's used by the body of a range over function iterator,
+// which runs in the yield function and may be called by the iterator in another goroutine. It's always 0 in counter mode
+func Goroutine() int64 {
+	if counterMode {
+		return 0
+	}
 	return gid.Get()
 }
 
+// Bind links current goroutine to its parent
 func Bind(parent int64) {
-	fmt.Printf("bind parernt: %d:%d\n", parent, gid.Get())
+	if counterMode {
+		return
+	}
+	push(kindBind, gid.Get(), 0, uint64(parent))
+}
+
+// Exit records a function exit, it must be called by defer directly so that recover works.
+// A panic is recovered and captured with its type and message by the innermost instrumented function it unwinds, and then raised again,
+// outer functions record the exit as panicked without recovering, so the stack trace only has one more frame of the re-panic
+func Exit(id int64, x uint64) {
+	if counterMode {
+		count(x)
+		return
+	}
+	if unwinding(id) {
+		push(kindExit, id, x, 1)
+		return
+	}
+	if r := recover(); r != nil {
+		capturePanic(id, x, r)
+		push(kindExit, id, x, 1)
+		panic(r)
+	}
+	push(kindExit, id, x, 0)
+}
+
+// Recover records a recover call site, v is the result of recover, which is returned as is
+func Recover(id int64, x uint64, v interface{}) interface{} {
+	if v != nil {
+		recovered(id)
+		push(kindRecover, id, x, 1)
+	} else {
+		push(kindRecover, id, x, 0)
+	}
+	return v
+}
+
+// Defer records a function is deferred
+func Defer(id int64, x uint64) {
+	push(kindDefer, id, x, 0)
+}
+
+// Now returns current time, it's the start time passed to Init
+func Now() time.Time {
+	return time.Now()
+}
+
+// Init records an init function returns, with the duration since start
+func Init(id int64, x uint64, start time.Time) {
+	push(kindInit, id, x, uint64(time.Since(start)))
+}
+
+// Cond records the value of an operand in a condition, which is returned as is
+func Cond(id int64, x uint64, v bool) bool {
+	if v {
+		push(kindCond, id, x, 1)
+	} else {
+		push(kindCond, id, x, 0)
+	}
+	return v
+}
+
+// Type records a case of type switch is chosen, v is the symbol of the guard
+func Type(id int64, x uint64, v interface{}) {
+	if counterMode {
+		count(x)
+		return
+	}
+	push(kindMatch, id, x, uint64(registerType(v)))
+}
+
+// Select records a case of select is chosen, start is the time the select begins
+func Select(id int64, x uint64, start time.Time) {
+	push(kindSelect, id, x, uint64(time.Since(start)))
 }
@@ -0,0 +1,130 @@
+package sdk
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/Unixeno/gootprint/trace"
+)
+
+// ModeEnv is the environment variable to select the sdk mode, set it to ModeCounter to count hits of points
+// instead of recording events, goroutines are not identified and no trace events are written,
+// the output is a counter file dumped by Flush, which must be called before the program exits
+const ModeEnv = "GOOTPRINT_MODE"
+
+const ModeCounter = "counter"
+
+// DumpIntervalEnv is the environment variable to dump counters periodically in counter mode, such as `1m`,
+// counters are always dumped by Flush when the program exits
+const DumpIntervalEnv = "GOOTPRINT_DUMP_INTERVAL"
+
+var counterMode = os.Getenv(ModeEnv) == ModeCounter
+
+const (
+	chunkBits = 8
+	chunkSize = 1 << chunkBits
+	chunkMask = chunkSize - 1
+	maxChunks = 1 << 16
+)
+
+// counters are indexed by the registration order of points, so they don't depend on point ids.
+// Chunks are allocated when points are registered, which happens before the points are used
+// by package initialization, so they are read without lock, and a counter is never moved once it's used
+var counters [maxChunks]*[chunkSize]uint64
+
+var counterOutput string // file to dump counters, `-` for stderr
+
+func initCounter() {
+	counterOutput = os.Getenv(OutputEnv)
+	if counterOutput == "" {
+		counterOutput = fmt.Sprintf("gootprint.%d.counts", os.Getpid())
+	}
+	if interval := os.Getenv(DumpIntervalEnv); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			fmt.Fprintf(os.Stderr, "gootprint: invalid dump interval `%s`, dump on exit only\n", interval)
+			return
+		}
+		go dumper(d)
+	}
+}
+
+func dumper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := Dump(); err != nil {
+			fmt.Fprintf(os.Stderr, "gootprint: failed to dump counters: %v\n", err)
+		}
+	}
+}
+
+// counter returns the counter at index, it must be allocated
+func counter(index uint64) *uint64 {
+	return &counters[index>>chunkBits][index&chunkMask]
+}
+
+// count increments the counter at index, which is returned by NewE in counter mode,
+// it's lock-free and never allocates
+func count(index uint64) {
+	atomic.AddUint64(counter(index), 1)
+}
+
+// allocCounter allocates the counter of the point registered last, and returns its index,
+// outputLock must be held
+func allocCounter() uint64 {
+	index := len(points) - 1
+	if index>>chunkBits >= maxChunks {
+		panic("gootprint: too many points for counter mode")
+	}
+	if counters[index>>chunkBits] == nil {
+		counters[index>>chunkBits] = &[chunkSize]uint64{}
+	}
+	return uint64(index)
+}
+
+// Dump writes hits of all points to the counter file in counter mode, it's a trace file with count records,
+// points never hit are only in the header. The file is replaced as a whole, so it can be called at any time
+// to take a snapshot, it does nothing in other modes
+func Dump() error {
+	if !counterMode {
+		return nil
+	}
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	if counterOutput == "-" {
+		return writeCounters(os.Stderr)
+	}
+	f, err := os.CreateTemp(filepath.Dir(counterOutput), filepath.Base(counterOutput)+".*")
+	if err != nil {
+		return err
+	}
+	if err = f.Chmod(0644); err == nil {
+		err = writeCounters(f)
+	}
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), counterOutput)
+}
+
+// writeCounters writes registered files and points with their hits, outputLock must be held
+func writeCounters(f *os.File) error {
+	w := trace.NewWriter(f)
+	w.WriteHeader(files, points)
+	for index, point := range points {
+		if hits := atomic.LoadUint64(counter(uint64(index))); hits > 0 {
+			w.WriteCount(point.ID, hits)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,252 @@
+package sdk
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Unixeno/gootprint/trace"
+)
+
+const drainInterval = 50 * time.Millisecond
+
+// OutputEnv is the environment variable to specify the output trace file,
+// use `-` for stderr
+const OutputEnv = "GOOTPRINT_OUTPUT"
+
+var (
+	outputLock    sync.Mutex // protect output, registry and draining
+	output        *trace.Writer
+	headerWritten bool
+	reported      uint64 // amount of dropped events already reported
+
+	files     []trace.File
+	fileIDs   = map[string]uint32{}
+	points    []trace.Point
+	panics    = map[int64]trace.Panic{} // goroutine id => the panic being unwound
+	panicking int32                     // amount of goroutines in panics, it's read without lock
+	types     uint32                    // amount of dynamic types registered
+	typeIDs   sync.Map                  // reflect.Type => type id, it's read without lock
+)
+
+func init() {
+	if counterMode {
+		initCounter()
+		return
+	}
+	var target io.Writer = os.Stderr
+	name := os.Getenv(OutputEnv)
+	if name == "" {
+		name = fmt.Sprintf("gootprint.%d.trace", os.Getpid())
+	}
+	if name != "-" {
+		fd, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gootprint: failed to open output `%s`, fallback to stderr: %v\n", name, err)
+		} else {
+			target = fd
+		}
+	}
+	output = trace.NewWriter(target)
+	initRings()
+	go drainer()
+}
+
+// drainer moves buffered events to output and flushes them every tick, so events are on disk
+// even if the program exits without Flush, such as by os.Exit or a signal
+func drainer() {
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	reportedErr := false
+	for range ticker.C {
+		outputLock.Lock()
+		drainAll()
+		err := output.Flush() // it's nothing if the buffer is empty
+		outputLock.Unlock()
+		if err != nil && !reportedErr {
+			fmt.Fprintf(os.Stderr, "gootprint: failed to flush output: %v\n", err)
+			reportedErr = true
+		}
+	}
+}
+
+// writeHeader writes the trace header with files and points registered so far,
+// outputLock must be held
+func writeHeader() {
+	if !headerWritten {
+		output.WriteHeader(files, points)
+		headerWritten = true
+	}
+}
+
+// drainAll moves all buffered events to output, outputLock must be held
+func drainAll() {
+	writeHeader()
+	for _, r := range shards {
+		r.drain(writeSlot)
+	}
+	if d := atomic.LoadUint64(&dropped); d != reported {
+		output.WriteDrop(d - reported)
+		reported = d
+	}
+}
+
+func writeSlot(s *slot) {
+	switch s.kind {
+	case kindCollect:
+		output.WriteCollect(s.gid, s.id)
+	case kindCall:
+		output.WriteCall(s.gid, s.id)
+	case kindBind:
+		output.WriteBind(s.gid, int64(s.aux))
+	case kindExit:
+		output.WriteExit(s.gid, s.id, s.aux != 0)
+	case kindRecover:
+		output.WriteRecover(s.gid, s.id, s.aux != 0)
+	case kindDefer:
+		output.WriteDefer(s.gid, s.id)
+	case kindInit:
+		output.WriteInit(s.gid, s.id, time.Duration(s.aux))
+	case kindCond:
+		output.WriteCond(s.gid, s.id, s.aux != 0)
+	case kindLoop:
+		output.WriteLoop(s.gid, s.id, s.aux>>loopReasonBits, trace.LoopReason(s.aux&loopReasonMask))
+	case kindMatch:
+		output.WriteMatch(s.gid, s.id, uint32(s.aux))
+	case kindSelect:
+		output.WriteSelect(s.gid, s.id, time.Duration(s.aux))
+	}
+}
+
+func registerFile(filename string) uint32 {
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	if id, ok := fileIDs[filename]; ok {
+		return id
+	}
+	file := trace.File{ID: uint32(len(files)), Name: filename}
+	files = append(files, file)
+	fileIDs[filename] = file.ID
+	if headerWritten {
+		drainAll()
+		output.WriteFile(file)
+	}
+	return file.ID
+}
+
+// registerPoint returns the id of point passed to collecting functions, it's the index of its counter in counter mode
+func registerPoint(id uint64, filename string, path string) uint64 {
+	fileID := registerFile(filename)
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	point := trace.Point{ID: id, File: fileID, Path: path}
+	points = append(points, point)
+	if counterMode {
+		return allocCounter()
+	}
+	if headerWritten {
+		drainAll()
+		output.WritePoint(point)
+	}
+	return id
+}
+
+// registerType returns the id of the dynamic type of v, the type is written to output when it's first seen,
+// so it's always before the events of it
+func registerType(v interface{}) uint32 {
+	t := reflect.TypeOf(v)
+	if id, ok := typeIDs.Load(t); ok {
+		return id.(uint32)
+	}
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	if id, ok := typeIDs.Load(t); ok {
+		return id.(uint32)
+	}
+	typ := trace.Type{ID: types, Name: fmt.Sprintf("%T", v)}
+	types++
+	drainAll() // header is written before the first type
+	output.WriteType(typ)
+	typeIDs.Store(t, typ.ID)
+	return typ.ID
+}
+
+// capturePanic records a panic when it's first seen in a goroutine, the exit hooks of outer functions
+// find it by unwinding, and report their exits as panicked. Panics are not recorded in counter mode
+func capturePanic(gid int64, id uint64, v interface{}) {
+	if counterMode {
+		return
+	}
+	p := trace.Panic{Goroutine: gid, Point: id, Type: fmt.Sprintf("%T", v), Message: fmt.Sprint(v)}
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	if last, ok := panics[gid]; ok && last.Type == p.Type && last.Message == p.Message {
+		return
+	}
+	if _, ok := panics[gid]; !ok {
+		atomic.AddInt32(&panicking, 1)
+	}
+	panics[gid] = p
+	drainAll() // keep the order of events in current goroutine
+	output.WritePanic(p)
+}
+
+// recovered forgets the panic being unwound in a goroutine
+func recovered(gid int64) {
+	if counterMode {
+		return
+	}
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	if _, ok := panics[gid]; ok {
+		atomic.AddInt32(&panicking, -1)
+		delete(panics, gid)
+	}
+}
+
+// unwinding reports whether the caller of Exit is called by a panic, which is already captured in goroutine gid.
+// A panic recovered by code not instrumented is not forgotten, so another panic of the goroutine is not captured
+func unwinding(gid int64) bool {
+	if atomic.LoadInt32(&panicking) == 0 {
+		return false
+	}
+	var pcs [1]uintptr
+	if runtime.Callers(3, pcs[:]) == 0 { // skip Callers, unwinding and Exit
+		return false
+	}
+	if frame, _ := runtime.CallersFrames(pcs[:]).Next(); frame.Function != "runtime.gopanic" {
+		return false
+	}
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	_, ok := panics[gid]
+	return ok
+}
+
+// Flushed flushes the sdk and returns code as is, it wraps the exit code in TestMain, as os.Exit skips defers
+func Flushed(code int) int {
+	Flush()
+	return code
+}
+
+// Flush drains all buffered events and flushes them to output, counters are dumped instead in counter mode,
+// it should be called before the program exits, otherwise the latest events may be lost
+func Flush() {
+	if counterMode {
+		if err := Dump(); err != nil {
+			fmt.Fprintf(os.Stderr, "gootprint: failed to dump counters: %v\n", err)
+		}
+		return
+	}
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	drainAll()
+	if err := output.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "gootprint: failed to flush output: %v\n", err)
+	}
+}
@@ -0,0 +1,49 @@
+package sdk
+
+import (
+	"github.com/Unixeno/gootprint/trace"
+)
+
+// the exit reason is kept in the low bits of the record, iterations are in the high bits
+const loopReasonBits = 2
+const loopReasonMask = 1<<loopReasonBits - 1
+
+// Loop counts iterations of a loop, it's declared before the loop in loop mode,
+// so every entry of the loop starts from zero
+type Loop struct {
+	iterations uint64
+	broken     bool
+}
+
+// Next counts an iteration, it's called at the beginning of loop body
+func (l *Loop) Next() {
+	l.iterations++
+}
+
+// Break marks the loop is finished by break, it's recorded by Exit after the loop
+func (l *Loop) Break() {
+	l.broken = true
+}
+
+// Exit records the loop finishes by its condition or break
+func (l *Loop) Exit(id int64, x uint64) {
+	if l.broken {
+		l.record(id, x, trace.LoopBreak)
+	} else {
+		l.record(id, x, trace.LoopCondition)
+	}
+}
+
+// Return records the loop is left by return
+func (l *Loop) Return(id int64, x uint64) {
+	l.record(id, x, trace.LoopReturn)
+}
+
+// Jump records the loop is left by goto, or break and continue of an outer label
+func (l *Loop) Jump(id int64, x uint64) {
+	l.record(id, x, trace.LoopJump)
+}
+
+func (l *Loop) record(id int64, x uint64, reason trace.LoopReason) {
+	push(kindLoop, id, x, l.iterations<<loopReasonBits|uint64(reason))
+}
@@ -0,0 +1,126 @@
+package sdk
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+const (
+	slotBits  = 13
+	slotCount = 1 << slotBits // slots per shard, must be power of 2
+	slotMask  = slotCount - 1
+	maxShards = 256
+)
+
+// record kinds stored in ring slots
+const (
+	kindCollect uint8 = iota + 1
+	kindCall
+	kindBind
+	kindExit
+	kindRecover
+	kindDefer
+	kindInit
+	kindCond
+	kindLoop
+	kindMatch
+	kindSelect
+)
+
+// slot is a single element in a ring, seq is used to synchronize producers and the consumer:
+//
+//	seq == pos       the slot is free for producer at position pos
+//	seq == pos + 1   the slot is filled and waiting for the consumer
+type slot struct {
+	seq  uint64
+	gid  int64
+	aux  uint64
+	id   uint64
+	kind uint8
+}
+
+// ring is a bounded multi-producer single-consumer queue, goroutines mapped to the same shard
+// share one ring, only the drainer consumes it
+type ring struct {
+	tail  uint64 // next position to write, updated by producers
+	_     [56]byte
+	head  uint64 // next position to read, only accessed by the drainer
+	_     [56]byte
+	slots [slotCount]slot
+}
+
+var shards []*ring
+var shardMask int64
+var dropped uint64 // events dropped because a ring was full
+
+// initRings allocates a ring per shard, it must be done before the drainer starts
+func initRings() {
+	n := 1
+	for n < runtime.GOMAXPROCS(0)*2 && n < maxShards {
+		n <<= 1
+	}
+	shards = make([]*ring, n)
+	for i := range shards {
+		shards[i] = newRing()
+	}
+	shardMask = int64(n - 1)
+}
+
+func newRing() *ring {
+	r := &ring{}
+	for i := range r.slots {
+		r.slots[i].seq = uint64(i)
+	}
+	return r
+}
+
+// push puts a record into the shard of goroutine gid, it never blocks nor allocates,
+// the record is dropped if the ring is full. In counter mode, only the point is counted
+func push(kind uint8, gid int64, id uint64, aux uint64) {
+	if counterMode {
+		count(id)
+		return
+	}
+	shards[gid&shardMask].push(kind, gid, id, aux)
+}
+
+// push puts a record into the ring, it reports false and counts the record as dropped if the ring is full
+func (r *ring) push(kind uint8, gid int64, id uint64, aux uint64) bool {
+	pos := atomic.LoadUint64(&r.tail)
+	for {
+		s := &r.slots[pos&slotMask]
+		seq := atomic.LoadUint64(&s.seq)
+		switch diff := int64(seq - pos); {
+		case diff == 0:
+			if atomic.CompareAndSwapUint64(&r.tail, pos, pos+1) {
+				s.kind = kind
+				s.gid = gid
+				s.id = id
+				s.aux = aux
+				atomic.StoreUint64(&s.seq, pos+1)
+				return true
+			}
+			pos = atomic.LoadUint64(&r.tail)
+		case diff < 0: // full, the drainer is too slow
+			atomic.AddUint64(&dropped, 1)
+			return false
+		default: // another producer took this slot
+			pos = atomic.LoadUint64(&r.tail)
+		}
+	}
+}
+
+// drain consumes all filled slots in the ring, must only be called by one goroutine at a time
+func (r *ring) drain(handle func(s *slot)) int {
+	n := 0
+	for {
+		s := &r.slots[r.head&slotMask]
+		if atomic.LoadUint64(&s.seq) != r.head+1 {
+			return n
+		}
+		handle(s)
+		atomic.StoreUint64(&s.seq, r.head+slotCount)
+		r.head++
+		n++
+	}
+}
@@ -0,0 +1,96 @@
+package sdk
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestMain removes the default trace file, it's opened by init before tests run
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if os.Getenv(OutputEnv) == "" {
+		os.Remove(fmt.Sprintf("gootprint.%d.trace", os.Getpid()))
+	}
+	os.Exit(code)
+}
+
+// producers push more records than the ring holds while it's drained, every record is consumed once in order of its producer
+func TestRingConcurrent(t *testing.T) {
+	const producers, records = 8, 4 * slotCount
+	r := newRing()
+	var wg sync.WaitGroup
+	for g := 1; g <= producers; g++ {
+		wg.Add(1)
+		go func(gid int64) {
+			defer wg.Done()
+			for i := uint64(0); i < records; i++ {
+				for !r.push(kindCollect, gid, i, uint64(gid)) {
+					runtime.Gosched()
+				}
+			}
+		}(int64(g))
+	}
+
+	next := map[int64]uint64{} // producer => id of its next record
+	received := 0
+	for received < producers*records {
+		n := r.drain(func(s *slot) {
+			if s.kind != kindCollect || s.aux != uint64(s.gid) {
+				t.Fatalf("corrupted record %+v", *s)
+			}
+			if s.id != next[s.gid] {
+				t.Fatalf("record %d of producer %d is received, want %d", s.id, s.gid, next[s.gid])
+			}
+			next[s.gid]++
+		})
+		if n == 0 {
+			runtime.Gosched()
+		}
+		received += n
+	}
+	wg.Wait()
+	if n := r.drain(func(s *slot) {}); n != 0 {
+		t.Errorf("%d records are received after all are consumed", n)
+	}
+}
+
+// records pushed into a full ring are dropped and counted, the ring accepts records again once drained
+func TestRingFull(t *testing.T) {
+	r := newRing()
+	before := atomic.LoadUint64(&dropped)
+	for i := uint64(0); i < slotCount; i++ {
+		if !r.push(kindCollect, 1, i, 0) {
+			t.Fatalf("record %d is dropped before the ring is full", i)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if r.push(kindCollect, 1, slotCount, 0) {
+			t.Fatal("record is stored into a full ring")
+		}
+	}
+	if n := atomic.LoadUint64(&dropped) - before; n != 3 {
+		t.Errorf("%d records are counted as dropped, want 3", n)
+	}
+
+	if n := r.drain(func(s *slot) {}); n != slotCount {
+		t.Errorf("%d records are drained, want %d", n, slotCount)
+	}
+	if !r.push(kindCollect, 1, slotCount, 0) {
+		t.Error("record is dropped after the ring is drained")
+	}
+}
+
+func TestRingPushDoesNotAllocate(t *testing.T) {
+	r := newRing()
+	allocs := testing.AllocsPerRun(1000, func() {
+		r.push(kindCall, 1, 0x1234560001, 0)
+		r.drain(func(s *slot) {})
+	})
+	if allocs != 0 {
+		t.Errorf("push allocates %v times", allocs)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Unixeno/gootprint/frame"
+	log "github.com/sirupsen/logrus"
+)
+
+// testMainFilename is the file added to packages without TestMain, it only exists in the overlay
+const testMainFilename = "gootprint_testmain_test.go"
+
+const testMainTemplate = `package %s
+
+import (
+	_g_os "os"
+	_g_testing "testing"
+
+	%s
+)
+
+// TestMain is generated by gootprint, the sdk is flushed after tests, as main.main is not run by tests
+func TestMain(m *_g_testing.M) {
+	code := m.Run()
+	%sFlush()
+	_g_os.Exit(code)
+}
+`
+
+// hookTestMain makes the test binary of a package flush the sdk when tests finish, a TestMain is added
+// to the overlay if the package has none, otherwise the existing one flushes before it exits
+func (p *Processor) hookTestMain(dir string) {
+	testFiles, err := filepath.Glob(filepath.Join(dir, "*_test.go"))
+	if err != nil || len(testFiles) == 0 {
+		return
+	}
+	sort.Strings(testFiles)
+	fSet := token.NewFileSet()
+	packageName := ""
+	for _, filename := range testFiles {
+		f, err := parser.ParseFile(fSet, filename, nil, 0)
+		if err != nil {
+			log.WithError(err).Warnf("failed to parse test file %s, skip TestMain", filename)
+			return
+		}
+		if !strings.HasSuffix(f.Name.Name, "_test") {
+			packageName = f.Name.Name
+		}
+		if testMain := findTestMain(f); testMain != nil {
+			p.flushTestMain(filename, fSet, f, testMain)
+			return
+		}
+	}
+	if packageName == "" { // only external tests, the package name is from source files
+		for _, filename := range p.overlaySources(dir) {
+			if f, err := parser.ParseFile(fSet, filename, nil, parser.PackageClauseOnly); err == nil {
+				packageName = f.Name.Name
+				break
+			}
+		}
+	}
+	if packageName == "" {
+		log.Warnf("failed to find package name of %s, skip TestMain", dir)
+		return
+	}
+	filename := filepath.Join(dir, testMainFilename)
+	content := fmt.Sprintf(testMainTemplate, packageName, frame.SDKPackage, frame.SDKPackagePrefix)
+	p.writeOverlayFile(filename, []byte(content))
+}
+
+// overlaySources returns source files of dir which are instrumented in the overlay
+func (p *Processor) overlaySources(dir string) []string {
+	var sources []string
+	for source := range p.overlay {
+		if filepath.Dir(source) == dir {
+			sources = append(sources, source)
+		}
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+func findTestMain(f *ast.File) *ast.FuncDecl {
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && fn.Name.Name == "TestMain" && fn.Body != nil {
+			return fn
+		}
+	}
+	return nil
+}
+
+// flushTestMain rewrites the test file with TestMain, the sdk is flushed by defer when TestMain returns,
+// and before os.Exit in it, which skips defers:
+//
+//	os.Exit(m.Run()) => os.Exit(_g_sdk.Flushed(m.Run()))
+func (p *Processor) flushTestMain(filename string, fSet *token.FileSet, f *ast.File, testMain *ast.FuncDecl) {
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		log.WithError(err).Warnf("failed to read test file %s, skip TestMain", filename)
+		return
+	}
+	osName := ""
+	for _, spec := range f.Imports {
+		if path, err := strconv.Unquote(spec.Path.Value); err == nil && path == "os" {
+			osName = "os"
+			if spec.Name != nil {
+				osName = spec.Name.Name
+			}
+		}
+	}
+	type insertion struct {
+		offset int
+		text   string
+	}
+	insertions := []insertion{
+		{fSet.Position(f.Name.End()).Offset, "; import " + frame.SDKPackage}, // in the same line, keep line numbers
+		{fSet.Position(testMain.Body.Lbrace).Offset + 1, "defer " + frame.SDKPackagePrefix + "Flush();"},
+	}
+	ast.Inspect(testMain.Body, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok || len(call.Args) != 1 {
+			return true
+		}
+		if sel, ok := call.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "Exit" {
+			if x, ok := sel.X.(*ast.Ident); ok && osName != "" && x.Name == osName && x.Obj == nil {
+				insertions = append(insertions,
+					insertion{fSet.Position(call.Lparen).Offset + 1, frame.SDKPackagePrefix + "Flushed("},
+					insertion{fSet.Position(call.Rparen).Offset, ")"})
+			}
+		}
+		return true
+	})
+	sort.SliceStable(insertions, func(i, j int) bool {
+		return insertions[i].offset < insertions[j].offset
+	})
+	buf := bytes.NewBuffer(make([]byte, 0, len(source)+256))
+	last := 0
+	for _, in := range insertions {
+		buf.Write(source[last:in.offset])
+		buf.WriteString(in.text)
+		last = in.offset
+	}
+	buf.Write(source[last:])
+	p.writeOverlayFile(filename, buf.Bytes())
+}
+
+// writeOverlayFile writes content to the overlay as the replacement of filename, which may not exist
+func (p *Processor) writeOverlayFile(filename string, content []byte) {
+	output := p.outputFilename(filename)
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		log.WithError(err).Fatal("failed to create output directory")
+	}
+	if err := os.WriteFile(output, content, 0644); err != nil {
+		log.WithError(err).Fatalf("failed to write %s", output)
+	}
+	p.overlay[filename] = output
+	log.Infof("TestMain hooked: %s", filename)
+}
@@ -0,0 +1,257 @@
+// Package trace implements the binary trace format written by the gootprint sdk.
+//
+// A trace file begins with a header:
+//
+//	magic   "GOOTPRNT"
+//	version uvarint
+//	files   uvarint count, followed by count file tables entries: uvarint id, string name
+//	points  uvarint count, followed by count point table entries: uvarint id, uvarint file id, string path
+//
+// and is followed by records, each record starts with a kind byte, integers are varint encoded
+// and strings are encoded as an uvarint length followed by the bytes.
+// Files and points registered after the header was written are appended as records.
+// Counters dumped by the sdk in counter mode use the same format, with a count record for every point hit.
+//
+// Point ids are 64 bits, assigned by the generator: the high 48 bits are the hash of the file name relative
+// to its module, the low 16 bits are the index of the point in the file. The hash is wider than 32 bits
+// to keep files of large programs from colliding, which would merge their points.
+package trace
+
+import (
+	"errors"
+	"time"
+)
+
+const Magic = "GOOTPRNT"
+
+const Version = 2
+
+type Kind uint8
+
+const (
+	KindFile    Kind = iota + 1 // uvarint id, string name
+	KindPoint                   // uvarint id, uvarint file id, string path
+	KindCollect                 // uvarint goroutine id, uvarint point id
+	KindCall                    // uvarint goroutine id, uvarint point id
+	KindBind                    // uvarint goroutine id, uvarint parent goroutine id
+	KindDrop                    // uvarint amount of dropped events
+	KindExit                    // uvarint goroutine id, uvarint point id, uvarint 1 if panicked else 0
+	KindPanic                   // uvarint goroutine id, uvarint point id, string type, string message
+	KindRecover                 // uvarint goroutine id, uvarint point id, uvarint 1 if a panic is recovered else 0
+	KindDefer                   // uvarint goroutine id, uvarint point id
+	KindInit                    // uvarint goroutine id, uvarint point id, uvarint duration in nanoseconds
+	KindCond                    // uvarint goroutine id, uvarint point id, uvarint 1 if the operand is true else 0
+	KindLoop                    // uvarint goroutine id, uvarint point id, uvarint iterations, uvarint exit reason
+	KindType                    // uvarint id, string name
+	KindMatch                   // uvarint goroutine id, uvarint point id, uvarint type id
+	KindSelect                  // uvarint goroutine id, uvarint point id, uvarint blocked time in nanoseconds
+	KindCount                   // uvarint point id, uvarint hits
+)
+
+// MaxStringLen is the longest string a reader accepts, a longer length is taken as a corrupted file
+const MaxStringLen = 16 << 20
+
+var ErrBadMagic = errors.New("trace: not a gootprint trace file")
+var ErrVersion = errors.New("trace: unsupported version")
+var ErrTooLong = errors.New("trace: string too long")
+
+func (k Kind) String() string {
+	switch k {
+	case KindFile:
+		return "file"
+	case KindPoint:
+		return "point"
+	case KindCollect:
+		return "collect"
+	case KindCall:
+		return "call"
+	case KindBind:
+		return "bind"
+	case KindDrop:
+		return "drop"
+	case KindExit:
+		return "exit"
+	case KindPanic:
+		return "panic"
+	case KindRecover:
+		return "recover"
+	case KindDefer:
+		return "defer"
+	case KindInit:
+		return "init"
+	case KindCond:
+		return "cond"
+	case KindLoop:
+		return "loop"
+	case KindType:
+		return "type"
+	case KindMatch:
+		return "match"
+	case KindSelect:
+		return "select"
+	case KindCount:
+		return "count"
+	}
+	return "unknown"
+}
+
+// Event is a decoded record
+type Event interface {
+	Kind() Kind
+}
+
+type File struct {
+	ID   uint32
+	Name string
+}
+
+type Point struct {
+	ID   uint64
+	File uint32
+	Path string
+}
+
+// Collect is recorded every time an instrumented point is reached
+type Collect struct {
+	Goroutine int64
+	Point     uint64
+}
+
+// Call is recorded when an instrumented function is called
+type Call struct {
+	Goroutine int64
+	Point     uint64
+}
+
+// Bind links a goroutine to the goroutine which started it
+type Bind struct {
+	Goroutine int64
+	Parent    int64
+}
+
+// Exit is recorded when an instrumented function returns or panics, only in exit defer mode
+type Exit struct {
+	Goroutine int64
+	Point     uint64
+	Panicked  bool
+}
+
+// Panic is recorded by the exit hook of the function where a panic is first seen,
+// Point is the exit point of the function
+type Panic struct {
+	Goroutine int64
+	Point     uint64
+	Type      string // dynamic type of the panic value
+	Message   string
+}
+
+// Recover is recorded every time an instrumented recover call site is executed
+type Recover struct {
+	Goroutine int64
+	Point     uint64
+	Recovered bool // whether recover returned a non-nil value
+}
+
+// Defer is recorded when a function is deferred, running of the deferred function is recorded as a call
+type Defer struct {
+	Goroutine int64
+	Point     uint64
+}
+
+// Init is recorded when a package init function returns, init functions run one by one
+// in the order of package initialization
+type Init struct {
+	Goroutine int64
+	Point     uint64
+	Duration  time.Duration
+}
+
+// Cond is recorded every time an operand of a condition is evaluated, operands skipped by short circuit are not
+type Cond struct {
+	Goroutine int64
+	Point     uint64
+	Value     bool
+}
+
+// LoopReason tells how a loop finishes
+type LoopReason uint8
+
+const (
+	LoopCondition LoopReason = iota // condition is false or range is done
+	LoopBreak                       // break of the loop
+	LoopReturn                      // return from the function
+	LoopJump                        // goto, break or continue of an outer label
+)
+
+func (r LoopReason) String() string {
+	switch r {
+	case LoopCondition:
+		return "condition"
+	case LoopBreak:
+		return "break"
+	case LoopReturn:
+		return "return"
+	case LoopJump:
+		return "jump"
+	}
+	return "unknown"
+}
+
+// Loop is recorded once a loop finishes in loop mode, instead of collecting every iteration.
+// A loop left by panic is not recorded
+type Loop struct {
+	Goroutine  int64
+	Point      uint64
+	Iterations uint64
+	Reason     LoopReason
+}
+
+// Type is a dynamic type seen by type switches, it's written before the first match of it
+type Type struct {
+	ID   uint32
+	Name string
+}
+
+// Match is recorded when a case of type switch is chosen, Type is the dynamic type of the guard,
+// it's the matched type for cases of a single concrete type
+type Match struct {
+	Goroutine int64
+	Point     uint64
+	Type      uint32
+}
+
+// Select is recorded when a case of select is chosen, with the time the goroutine blocked in select
+type Select struct {
+	Goroutine int64
+	Point     uint64
+	Blocked   time.Duration
+}
+
+// Count is the hits of a point, it's written in counter mode instead of events
+type Count struct {
+	Point uint64
+	Hits  uint64
+}
+
+// Drop reports events lost because the sdk buffer was full
+type Drop struct {
+	Amount uint64
+}
+
+func (File) Kind() Kind    { return KindFile }
+func (Point) Kind() Kind   { return KindPoint }
+func (Collect) Kind() Kind { return KindCollect }
+func (Call) Kind() Kind    { return KindCall }
+func (Bind) Kind() Kind    { return KindBind }
+func (Drop) Kind() Kind    { return KindDrop }
+func (Exit) Kind() Kind    { return KindExit }
+func (Panic) Kind() Kind   { return KindPanic }
+func (Recover) Kind() Kind { return KindRecover }
+func (Defer) Kind() Kind   { return KindDefer }
+func (Init) Kind() Kind    { return KindInit }
+func (Cond) Kind() Kind    { return KindCond }
+func (Loop) Kind() Kind    { return KindLoop }
+func (Type) Kind() Kind    { return KindType }
+func (Match) Kind() Kind   { return KindMatch }
+func (Select) Kind() Kind  { return KindSelect }
+func (Count) Kind() Kind   { return KindCount }
@@ -0,0 +1,259 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Reader decodes a trace file, files and points from the header and records are kept,
+// so events can be resolved with File and Point
+type Reader struct {
+	r       *bufio.Reader
+	Version uint64
+	files   map[uint32]File
+	points  map[uint64]Point
+	types   map[uint32]Type
+}
+
+// NewReader reads the header of a trace file
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{
+		r:      bufio.NewReader(r),
+		files:  map[uint32]File{},
+		points: map[uint64]Point{},
+		types:  map[uint32]Type{},
+	}
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(reader.r, magic); err != nil || string(magic) != Magic {
+		return nil, ErrBadMagic
+	}
+	var err error
+	if reader.Version, err = reader.uvarint(); err != nil {
+		return nil, err
+	}
+	if reader.Version != Version {
+		return nil, fmt.Errorf("%w: %d", ErrVersion, reader.Version)
+	}
+	count, err := reader.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	for ; count > 0; count-- {
+		if _, err := reader.readFile(); err != nil {
+			return nil, err
+		}
+	}
+	if count, err = reader.uvarint(); err != nil {
+		return nil, err
+	}
+	for ; count > 0; count-- {
+		if _, err := reader.readPoint(); err != nil {
+			return nil, err
+		}
+	}
+	return reader, nil
+}
+
+// Next returns the next record, io.EOF is returned when there are no more records
+func (r *Reader) Next() (Event, error) {
+	kind, err := r.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch Kind(kind) {
+	case KindFile:
+		return r.readFile()
+	case KindPoint:
+		return r.readPoint()
+	case KindCollect:
+		g, p, err := r.pair()
+		return Collect{Goroutine: int64(g), Point: p}, err
+	case KindCall:
+		g, p, err := r.pair()
+		return Call{Goroutine: int64(g), Point: p}, err
+	case KindBind:
+		g, parent, err := r.pair()
+		return Bind{Goroutine: int64(g), Parent: int64(parent)}, err
+	case KindDrop:
+		amount, err := r.uvarint()
+		return Drop{Amount: amount}, err
+	case KindExit:
+		g, p, err := r.pair()
+		if err != nil {
+			return nil, err
+		}
+		panicked, err := r.uvarint()
+		return Exit{Goroutine: int64(g), Point: p, Panicked: panicked != 0}, err
+	case KindPanic:
+		return r.readPanic()
+	case KindDefer:
+		g, p, err := r.pair()
+		return Defer{Goroutine: int64(g), Point: p}, err
+	case KindInit:
+		g, p, err := r.pair()
+		if err != nil {
+			return nil, err
+		}
+		duration, err := r.uvarint()
+		return Init{Goroutine: int64(g), Point: p, Duration: time.Duration(duration)}, err
+	case KindRecover:
+		g, p, err := r.pair()
+		if err != nil {
+			return nil, err
+		}
+		recovered, err := r.uvarint()
+		return Recover{Goroutine: int64(g), Point: p, Recovered: recovered != 0}, err
+	case KindCond:
+		g, p, err := r.pair()
+		if err != nil {
+			return nil, err
+		}
+		value, err := r.uvarint()
+		return Cond{Goroutine: int64(g), Point: p, Value: value != 0}, err
+	case KindLoop:
+		g, p, err := r.pair()
+		if err != nil {
+			return nil, err
+		}
+		iterations, reason, err := r.pair()
+		return Loop{Goroutine: int64(g), Point: p, Iterations: iterations, Reason: LoopReason(reason)}, err
+	case KindType:
+		return r.readType()
+	case KindMatch:
+		g, p, err := r.pair()
+		if err != nil {
+			return nil, err
+		}
+		typ, err := r.uvarint()
+		return Match{Goroutine: int64(g), Point: p, Type: uint32(typ)}, err
+	case KindSelect:
+		g, p, err := r.pair()
+		if err != nil {
+			return nil, err
+		}
+		blocked, err := r.uvarint()
+		return Select{Goroutine: int64(g), Point: p, Blocked: time.Duration(blocked)}, err
+	case KindCount:
+		p, hits, err := r.pair()
+		return Count{Point: p, Hits: hits}, err
+	}
+	return nil, fmt.Errorf("trace: unknown record kind %d", kind)
+}
+
+// File returns a file from the file table
+func (r *Reader) File(id uint32) (File, bool) {
+	file, ok := r.files[id]
+	return file, ok
+}
+
+// Point returns a point from the point table
+func (r *Reader) Point(id uint64) (Point, bool) {
+	point, ok := r.points[id]
+	return point, ok
+}
+
+// Type returns a dynamic type from the type table
+func (r *Reader) Type(id uint32) (Type, bool) {
+	typ, ok := r.types[id]
+	return typ, ok
+}
+
+// Points returns all points read so far, sorted by id
+func (r *Reader) Points() []Point {
+	points := make([]Point, 0, len(r.points))
+	for _, point := range r.points {
+		points = append(points, point)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].ID < points[j].ID
+	})
+	return points
+}
+
+func (r *Reader) readFile() (File, error) {
+	id, err := r.uvarint()
+	if err != nil {
+		return File{}, err
+	}
+	name, err := r.string()
+	file := File{ID: uint32(id), Name: name}
+	if err == nil {
+		r.files[file.ID] = file
+	}
+	return file, err
+}
+
+func (r *Reader) readPoint() (Point, error) {
+	id, fileID, err := r.pair()
+	if err != nil {
+		return Point{}, err
+	}
+	path, err := r.string()
+	point := Point{ID: id, File: uint32(fileID), Path: path}
+	if err == nil {
+		r.points[point.ID] = point
+	}
+	return point, err
+}
+
+func (r *Reader) readType() (Type, error) {
+	id, err := r.uvarint()
+	if err != nil {
+		return Type{}, err
+	}
+	name, err := r.string()
+	typ := Type{ID: uint32(id), Name: name}
+	if err == nil {
+		r.types[typ.ID] = typ
+	}
+	return typ, err
+}
+
+func (r *Reader) readPanic() (Panic, error) {
+	g, p, err := r.pair()
+	if err != nil {
+		return Panic{}, err
+	}
+	typ, err := r.string()
+	if err != nil {
+		return Panic{}, err
+	}
+	message, err := r.string()
+	return Panic{Goroutine: int64(g), Point: p, Type: typ, Message: message}, err
+}
+
+func (r *Reader) pair() (uint64, uint64, error) {
+	a, err := r.uvarint()
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := r.uvarint()
+	return a, b, err
+}
+
+func (r *Reader) uvarint() (uint64, error) {
+	v, err := binary.ReadUvarint(r.r)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return v, err
+}
+
+func (r *Reader) string() (string, error) {
+	n, err := r.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if n > MaxStringLen {
+		return "", fmt.Errorf("%w: %d bytes", ErrTooLong, n)
+	}
+	buf := make([]byte, n)
+	if _, err = io.ReadFull(r.r, buf); err != nil {
+		return "", io.ErrUnexpectedEOF
+	}
+	return string(buf), nil
+}
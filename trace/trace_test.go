@@ -0,0 +1,111 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	files := []File{{ID: 0, Name: "main.go"}}
+	points := []Point{{ID: 0x7bf5ef9f65a80001, File: 0, Path: "main.main"}}
+	events := []Event{
+		File{ID: 1, Name: "util.go"},
+		Point{ID: 0x1234560001, File: 1, Path: "main.util"},
+		Collect{Goroutine: 1, Point: 0x7bf5ef9f65a80001},
+		Call{Goroutine: 1, Point: 0x1234560001},
+		Bind{Goroutine: 2, Parent: 1},
+		Drop{Amount: 3},
+		Exit{Goroutine: 2, Point: 0x1234560001, Panicked: true},
+		Panic{Goroutine: 2, Point: 0x1234560001, Type: "string", Message: "boom"},
+		Recover{Goroutine: 1, Point: 0x1234560001, Recovered: true},
+		Defer{Goroutine: 1, Point: 0x1234560001},
+		Init{Goroutine: 1, Point: 0x1234560001, Duration: time.Millisecond},
+		Cond{Goroutine: 1, Point: 0x1234560001, Value: true},
+		Loop{Goroutine: 1, Point: 0x1234560001, Iterations: 1 << 40, Reason: LoopJump},
+		Type{ID: 0, Name: "*errors.errorString"},
+		Match{Goroutine: 1, Point: 0x1234560001, Type: 0},
+		Select{Goroutine: 1, Point: 0x1234560001, Blocked: time.Second},
+		Count{Point: 0x1234560001, Hits: 42},
+	}
+	buf := bytes.NewBuffer(nil)
+	w := NewWriter(buf)
+	w.WriteHeader(files, points)
+	for _, event := range events {
+		w.Write(event)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Version != Version {
+		t.Errorf("version is %d, want %d", r.Version, Version)
+	}
+	if file, ok := r.File(0); !ok || file != files[0] {
+		t.Errorf("file of header is %v, want %v", file, files[0])
+	}
+	if point, ok := r.Point(points[0].ID); !ok || point != points[0] {
+		t.Errorf("point of header is %v, want %v", point, points[0])
+	}
+	kinds := map[Kind]bool{}
+	for _, want := range events {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", want.Kind(), err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("read %#v, want %#v", got, want)
+		}
+		kinds[got.Kind()] = true
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("read after all records returns %v, want EOF", err)
+	}
+	for kind := KindFile; kind <= KindCount; kind++ {
+		if !kinds[kind] {
+			t.Errorf("kind %s is not covered", kind)
+		}
+	}
+	if point, ok := r.Point(0x1234560001); !ok || point.Path != "main.util" {
+		t.Errorf("point of record is not kept: %v", point)
+	}
+	if typ, ok := r.Type(0); !ok || typ.Name != "*errors.errorString" {
+		t.Errorf("type of record is not kept: %v", typ)
+	}
+}
+
+func TestBadMagic(t *testing.T) {
+	for _, content := range []string{"", "GOOT", "NOTTRACE\x02\x00\x00"} {
+		if _, err := NewReader(bytes.NewBufferString(content)); !errors.Is(err, ErrBadMagic) {
+			t.Errorf("reading %q returns %v, want %v", content, err, ErrBadMagic)
+		}
+	}
+}
+
+func TestBadVersion(t *testing.T) {
+	content := append([]byte(Magic), binary.AppendUvarint(nil, Version+1)...)
+	if _, err := NewReader(bytes.NewBuffer(content)); !errors.Is(err, ErrVersion) {
+		t.Errorf("reading version %d returns %v, want %v", Version+1, err, ErrVersion)
+	}
+}
+
+func TestCorruptedString(t *testing.T) {
+	header := append([]byte(Magic), binary.AppendUvarint(nil, Version)...)
+	header = append(header, 1, 0) // a file with id 0
+	tooLong := append(append([]byte{}, header...), binary.AppendUvarint(nil, 1<<40)...)
+	if _, err := NewReader(bytes.NewBuffer(tooLong)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("reading a string of 1TB returns %v, want %v", err, ErrTooLong)
+	}
+	truncated := append(append([]byte{}, header...), 10, 'm', 'a')
+	if _, err := NewReader(bytes.NewBuffer(truncated)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("reading a truncated string returns %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
@@ -0,0 +1,198 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// Writer encodes records into the trace format, errors are sticky and reported by Flush
+type Writer struct {
+	w       *bufio.Writer
+	scratch [1 + 4*binary.MaxVarintLen64]byte
+	err     error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriterSize(w, 64<<10)}
+}
+
+// WriteHeader writes the file header, it must be called before any other record
+func (w *Writer) WriteHeader(files []File, points []Point) {
+	w.write([]byte(Magic))
+	w.uvarints(Version, uint64(len(files)))
+	for _, file := range files {
+		w.uvarints(uint64(file.ID))
+		w.string(file.Name)
+	}
+	w.uvarints(uint64(len(points)))
+	for _, point := range points {
+		w.uvarints(point.ID, uint64(point.File))
+		w.string(point.Path)
+	}
+}
+
+func (w *Writer) WriteFile(file File) {
+	w.kind(KindFile, uint64(file.ID))
+	w.string(file.Name)
+}
+
+func (w *Writer) WritePoint(point Point) {
+	w.kind(KindPoint, point.ID, uint64(point.File))
+	w.string(point.Path)
+}
+
+func (w *Writer) WriteCollect(goroutine int64, point uint64) {
+	w.kind(KindCollect, uint64(goroutine), point)
+}
+
+func (w *Writer) WriteCall(goroutine int64, point uint64) {
+	w.kind(KindCall, uint64(goroutine), point)
+}
+
+func (w *Writer) WriteBind(goroutine, parent int64) {
+	w.kind(KindBind, uint64(goroutine), uint64(parent))
+}
+
+func (w *Writer) WriteExit(goroutine int64, point uint64, panicked bool) {
+	var flag uint64
+	if panicked {
+		flag = 1
+	}
+	w.kind(KindExit, uint64(goroutine), point, flag)
+}
+
+func (w *Writer) WritePanic(p Panic) {
+	w.kind(KindPanic, uint64(p.Goroutine), uint64(p.Point))
+	w.string(p.Type)
+	w.string(p.Message)
+}
+
+func (w *Writer) WriteRecover(goroutine int64, point uint64, recovered bool) {
+	var flag uint64
+	if recovered {
+		flag = 1
+	}
+	w.kind(KindRecover, uint64(goroutine), point, flag)
+}
+
+func (w *Writer) WriteDefer(goroutine int64, point uint64) {
+	w.kind(KindDefer, uint64(goroutine), point)
+}
+
+func (w *Writer) WriteInit(goroutine int64, point uint64, duration time.Duration) {
+	w.kind(KindInit, uint64(goroutine), point, uint64(duration))
+}
+
+func (w *Writer) WriteCond(goroutine int64, point uint64, value bool) {
+	var flag uint64
+	if value {
+		flag = 1
+	}
+	w.kind(KindCond, uint64(goroutine), point, flag)
+}
+
+func (w *Writer) WriteLoop(goroutine int64, point uint64, iterations uint64, reason LoopReason) {
+	w.kind(KindLoop, uint64(goroutine), point, iterations, uint64(reason))
+}
+
+func (w *Writer) WriteType(t Type) {
+	w.kind(KindType, uint64(t.ID))
+	w.string(t.Name)
+}
+
+func (w *Writer) WriteMatch(goroutine int64, point uint64, typ uint32) {
+	w.kind(KindMatch, uint64(goroutine), point, uint64(typ))
+}
+
+func (w *Writer) WriteSelect(goroutine int64, point uint64, blocked time.Duration) {
+	w.kind(KindSelect, uint64(goroutine), point, uint64(blocked))
+}
+
+func (w *Writer) WriteCount(point uint64, hits uint64) {
+	w.kind(KindCount, point, hits)
+}
+
+func (w *Writer) WriteDrop(amount uint64) {
+	w.kind(KindDrop, amount)
+}
+
+// Write writes a decoded record, so records can be copied from a Reader
+func (w *Writer) Write(event Event) {
+	switch e := event.(type) {
+	case File:
+		w.WriteFile(e)
+	case Point:
+		w.WritePoint(e)
+	case Collect:
+		w.WriteCollect(e.Goroutine, e.Point)
+	case Call:
+		w.WriteCall(e.Goroutine, e.Point)
+	case Bind:
+		w.WriteBind(e.Goroutine, e.Parent)
+	case Drop:
+		w.WriteDrop(e.Amount)
+	case Exit:
+		w.WriteExit(e.Goroutine, e.Point, e.Panicked)
+	case Panic:
+		w.WritePanic(e)
+	case Recover:
+		w.WriteRecover(e.Goroutine, e.Point, e.Recovered)
+	case Defer:
+		w.WriteDefer(e.Goroutine, e.Point)
+	case Init:
+		w.WriteInit(e.Goroutine, e.Point, e.Duration)
+	case Cond:
+		w.WriteCond(e.Goroutine, e.Point, e.Value)
+	case Loop:
+		w.WriteLoop(e.Goroutine, e.Point, e.Iterations, e.Reason)
+	case Type:
+		w.WriteType(e)
+	case Match:
+		w.WriteMatch(e.Goroutine, e.Point, e.Type)
+	case Select:
+		w.WriteSelect(e.Goroutine, e.Point, e.Blocked)
+	case Count:
+		w.WriteCount(e.Point, e.Hits)
+	}
+}
+
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.w.Flush()
+	return w.err
+}
+
+// kind writes a record kind followed by at most 4 integers
+func (w *Writer) kind(kind Kind, values ...uint64) {
+	w.scratch[0] = byte(kind)
+	n := 1
+	for _, v := range values {
+		n += binary.PutUvarint(w.scratch[n:], v)
+	}
+	w.write(w.scratch[:n])
+}
+
+func (w *Writer) uvarints(values ...uint64) {
+	n := 0
+	for _, v := range values {
+		n += binary.PutUvarint(w.scratch[n:], v)
+	}
+	w.write(w.scratch[:n])
+}
+
+func (w *Writer) string(s string) {
+	w.uvarints(uint64(len(s)))
+	if w.err == nil {
+		_, w.err = w.w.WriteString(s)
+	}
+}
+
+func (w *Writer) write(p []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(p)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// typedPackage is a package whose files are parsed together and checked by go/types
+type typedPackage struct {
+	fSet    *token.FileSet
+	files   map[string]*ast.File // absolute filename => syntax tree
+	sources map[string][]byte
+	pkg     *types.Package
+	info    *types.Info
+}
+
+// typeChecker checks packages by directory, imported packages are checked from source and cached by the importer,
+// all packages share a file set so positions of imported objects can be resolved
+type typeChecker struct {
+	fSet     *token.FileSet
+	importer types.Importer
+	packages map[string]*typedPackage // absolute filename => package
+	checked  map[string]bool          // directories already checked
+}
+
+func newTypeChecker() *typeChecker {
+	fSet := token.NewFileSet()
+	return &typeChecker{
+		fSet:     fSet,
+		importer: importer.ForCompiler(fSet, "source", nil),
+		packages: map[string]*typedPackage{},
+		checked:  map[string]bool{},
+	}
+}
+
+// Package returns the checked package of a source file, it's nil if the file is excluded by build constraints
+// or failed to parse, then the file should be parsed on its own
+func (c *typeChecker) Package(absFilename string) *typedPackage {
+	dir := filepath.Dir(absFilename)
+	if !c.checked[dir] {
+		c.checked[dir] = true
+		c.checkDir(dir)
+	}
+	return c.packages[absFilename]
+}
+
+// checkDir parses files in a directory which match build constraints, and checks them by package name.
+// Errors of type checking are logged, type information is still kept for the code checked
+func (c *typeChecker) checkDir(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.WithError(err).Errorf("failed to read directory %s for type checking", dir)
+		return
+	}
+	packages := map[string]*typedPackage{} // package name => package
+	var names []string
+	var files = map[string][]*ast.File{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") ||
+			strings.HasSuffix(name, "_test.go") || strings.HasSuffix(name, ".gen.go") {
+			continue
+		}
+		if match, err := build.Default.MatchFile(dir, name); err != nil || !match {
+			continue
+		}
+		filename := filepath.Join(dir, name)
+		source, err := os.ReadFile(filename)
+		if err != nil {
+			log.WithError(err).Errorf("failed to read %s for type checking", filename)
+			continue
+		}
+		node, err := parser.ParseFile(c.fSet, filename, source, 0)
+		if err != nil {
+			log.WithError(err).Errorf("failed to parse %s for type checking", filename)
+			continue
+		}
+		pkg, ok := packages[node.Name.Name]
+		if !ok {
+			pkg = &typedPackage{fSet: c.fSet, files: map[string]*ast.File{}, sources: map[string][]byte{}}
+			packages[node.Name.Name] = pkg
+			names = append(names, node.Name.Name)
+		}
+		pkg.files[filename] = node
+		pkg.sources[filename] = source
+		files[node.Name.Name] = append(files[node.Name.Name], node)
+	}
+
+	for _, name := range names {
+		pkg := packages[name]
+		pkg.info = &types.Info{
+			Types:      map[ast.Expr]types.TypeAndValue{},
+			Defs:       map[*ast.Ident]types.Object{},
+			Uses:       map[*ast.Ident]types.Object{},
+			Selections: map[*ast.SelectorExpr]*types.Selection{},
+		}
+		var errs []error
+		conf := types.Config{
+			Importer:    c.importer,
+			FakeImportC: true,
+			Error: func(err error) {
+				errs = append(errs, err)
+			},
+		}
+		pkg.pkg, _ = conf.Check(name, c.fSet, files[name], pkg.info)
+		if len(errs) > 0 {
+			log.Warnf("type checking of package `%s` in %s has %d errors, types are partially resolved, first error: %v",
+				name, dir, len(errs), errs[0])
+		}
+		for filename := range pkg.files {
+			c.packages[filename] = pkg
+		}
+		log.Infof("type checked package `%s` in %s", name, dir)
+	}
+}
+
+// referredObject returns the object referred by an identifier, a qualified identifier, or an instantiation of them,
+// it's nil if there is no type information or the expression refers to something else
+func (p *Parser) referredObject(expr ast.Expr) types.Object {
+	if p.info == nil {
+		return nil
+	}
+	switch typed := unparen(expr).(type) {
+	case *ast.Ident:
+		return p.info.Uses[typed]
+	case *ast.SelectorExpr: // pkg.Func, selectors of values and types are in selections
+		if _, ok := p.info.Selections[typed]; !ok {
+			return p.info.Uses[typed.Sel]
+		}
+	case *ast.IndexExpr: // Func[T]
+		return p.referredObject(typed.X)
+	case *ast.IndexListExpr: // Func[K, V]
+		return p.referredObject(typed.X)
+	}
+	return nil
+}
+
+// calledFunc returns the function or method called, it's nil for builtin functions and function values
+func (p *Parser) calledFunc(callExpr *ast.CallExpr) *types.Func {
+	if p.info == nil {
+		return nil
+	}
+	if sel, ok := unparen(callExpr.Fun).(*ast.SelectorExpr); ok {
+		if selection, ok := p.info.Selections[sel]; ok {
+			fn, _ := selection.Obj().(*types.Func)
+			return fn
+		}
+	}
+	fn, _ := p.referredObject(callExpr.Fun).(*types.Func)
+	return fn
+}
+
+// typeOf returns the type and value of an expression, ok is false if there is no type information for it
+func (p *Parser) typeOf(expr ast.Expr) (types.TypeAndValue, bool) {
+	if p.info == nil {
+		return types.TypeAndValue{}, false
+	}
+	tv, ok := p.info.Types[expr]
+	return tv, ok && tv.Type != nil
+}
+
+// paramType returns the source of the type of the i-th parameter of the function called, so an argument
+// can be assigned to a temporary of the same type. Only types with a basic underlying type are returned,
+// which untyped values are converted to, the others are the type of a typed argument or an interface,
+// where the default type of an untyped value is used. It's empty without type information,
+// or if the type can't be named in current file
+func (p *Parser) paramType(callExpr *ast.CallExpr, i int) string {
+	tv, ok := p.typeOf(callExpr.Fun)
+	if !ok || tv.IsType() {
+		return ""
+	}
+	sig, ok := tv.Type.Underlying().(*types.Signature)
+	if !ok {
+		return ""
+	}
+	params := sig.Params()
+	var t types.Type
+	switch {
+	case sig.Variadic() && i >= params.Len()-1:
+		t = params.At(params.Len() - 1).Type()
+		if !callExpr.Ellipsis.IsValid() {
+			t = t.(*types.Slice).Elem()
+		}
+	case i < params.Len():
+		t = params.At(i).Type()
+	default:
+		return ""
+	}
+	t = types.Unalias(t)
+	if _, ok := t.Underlying().(*types.Basic); !ok {
+		return ""
+	}
+	switch typed := t.(type) {
+	case *types.Basic:
+		return typed.Name()
+	case *types.Named:
+		obj := typed.Obj()
+		if obj.Pkg() == nil || typed.TypeArgs().Len() > 0 || obj.Parent() != obj.Pkg().Scope() {
+			return "" // predeclared error is not basic, types of functions are not in scope
+		}
+		if obj.Pkg() == p.pkg {
+			return obj.Name()
+		}
+		if name := p.importedName(obj.Pkg()); name != "" && obj.Exported() {
+			return name + "." + obj.Name()
+		}
+	}
+	return ""
+}
+
+// importedName returns the name of an imported package in current file, it's empty if the package is not imported
+func (p *Parser) importedName(pkg *types.Package) string {
+	for _, spec := range p.fileNode.Imports {
+		if path, err := strconv.Unquote(spec.Path.Value); err != nil || path != pkg.Path() {
+			continue
+		}
+		if spec.Name == nil {
+			return pkg.Name()
+		}
+		if spec.Name.Name != "_" && spec.Name.Name != "." {
+			return spec.Name.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// go commands which can be wrapped by gootprint, the whole module is instrumented before running them
+var goSubcommands = map[string]bool{
+	"build": true,
+	"run":   true,
+	"test":  true,
+}
+
+// runGoSubcommand instruments the module of current directory into a temporary overlay,
+// then runs `go <command>` with it, args are gootprint flags followed by arguments for the go command,
+// use `--` to separate them if the first go argument is a flag.
+// Source files are never touched, and the temporary directory is always removed.
+// It returns the exit code of the go command
+func runGoSubcommand(command string, args []string) int {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s %s [gootprint flags] [--] [go %s flags and arguments]\n",
+			os.Args[0], command, command)
+		flags.PrintDefaults()
+	}
+	instrument := registerInstrumentFlags(flags) // only instrumentation options, the overlay is managed by wrapper
+	registerLogFlags(flags)
+	registerExcludeFlags(flags)
+	_ = flags.Parse(args) // exit on error
+	setLogLevel()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.WithError(err).Fatal("failed to get current directory")
+	}
+	if !LocateModule(cwd) {
+		log.Fatalf("cannot find go mod file in `%s` and it's upper directory", cwd)
+	}
+
+	// signals are handled from now on, so the temporary directory can be removed: instrumentation is stopped
+	// on interrupt, and the go command is waited after it starts
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	processor := NewProcessor(PackageRoot, ModePackage)
+	var lock sync.Mutex
+	var process *os.Process // the go command, signals are forwarded to it once it starts
+	go func() {
+		for sig := range signals {
+			lock.Lock()
+			if process != nil {
+				_ = process.Signal(sig)
+			} else {
+				processor.Stop()
+			}
+			lock.Unlock()
+		}
+	}()
+
+	tmpDir, err := os.MkdirTemp("", "gootprint-")
+	if err != nil {
+		log.WithError(err).Fatal("failed to create temporary directory")
+	}
+	cleanup := func() {
+		log.Debugf("remove temporary directory %s", tmpDir)
+		if err := os.RemoveAll(tmpDir); err != nil {
+			log.WithError(err).Errorf("failed to remove temporary directory %s", tmpDir)
+		}
+	}
+	log.RegisterExitHandler(cleanup) // processor exits by log.Fatal on failure
+	defer cleanup()
+
+	processor.SetOptions(instrument.options())
+	processor.SetTypeCheck(*instrument.typeCheck)
+	processor.SetOverlay(tmpDir)
+	processor.SetTestMain(command == "test")
+	processor.Process()
+
+	goArgs := append([]string{command, "-overlay=" + processor.OverlayFilename()}, flags.Args()...)
+	log.Infof("run: go %v", goArgs)
+	cmd := exec.Command("go", goArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	lock.Lock()
+	if processor.Stopped() {
+		lock.Unlock()
+		log.Error("interrupted while instrumenting")
+		return 130
+	}
+	err = cmd.Start()
+	process = cmd.Process
+	lock.Unlock()
+	if err != nil {
+		log.WithError(err).Error("failed to start go command")
+		return 1
+	}
+
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	} else if err != nil {
+		log.WithError(err).Error("failed to run go command")
+		return 1
+	}
+	return 0
+}
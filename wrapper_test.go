@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/Unixeno/gootprint/trace"
+)
+
+const counterSample = `package sample
+
+func Sign(n int) int {
+	if n < 0 {
+		return -1
+	}
+	return 1
+}
+`
+
+const counterSampleTest = `package sample
+
+import "testing"
+
+func TestSign(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		if Sign(i) != 1 {
+			t.Fatal("wrong sign")
+		}
+	}
+}
+`
+
+// writeSampleModule writes a module named sample in dir, which requires gootprint of the working tree
+func writeSampleModule(t *testing.T, dir, goVersion string, files map[string]string) string {
+	t.Helper()
+	root, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	goSum, err := os.ReadFile("go.sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	module := filepath.Join(dir, "sample")
+	if err := os.MkdirAll(module, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files["go.mod"] = "module sample\n\ngo " + goVersion + "\n\nrequire github.com/Unixeno/gootprint v0.0.0\n\n" +
+		"replace github.com/Unixeno/gootprint => " + root + "\n"
+	files["go.sum"] = string(goSum)
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(module, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return module
+}
+
+// readTrace reads all events of a trace file, the reader is returned to look up points
+func readTrace(t *testing.T, filename string) (*trace.Reader, []trace.Event) {
+	t.Helper()
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("trace is not written: %v", err)
+	}
+	defer f.Close()
+	reader, err := trace.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []trace.Event
+	for {
+		event, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return reader, events
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+}
+
+// TestCounterModeWithGoTest runs tests of a module by `gootprint test` in counter mode,
+// counters are dumped when the test binary finishes
+func TestCounterModeWithGoTest(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds gootprint and runs go test")
+	}
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "gootprint")
+	if out, err := exec.Command("go", "build", "-o", binary, ".").CombinedOutput(); err != nil {
+		t.Fatalf("failed to build gootprint: %v\n%s", err, out)
+	}
+
+	module := writeSampleModule(t, dir, "1.16", map[string]string{
+		"sign.go":      counterSample,
+		"sign_test.go": counterSampleTest,
+	})
+
+	counts := filepath.Join(dir, "sample.counts")
+	cmd := exec.Command(binary, "test", "-s", "./...")
+	cmd.Dir = module
+	// names of sdk variables, the sdk is not imported as it opens the trace output when it's initialized
+	cmd.Env = append(os.Environ(), "GOOTPRINT_MODE=counter", "GOOTPRINT_OUTPUT="+counts)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("gootprint test failed: %v\n%s", err, out)
+	}
+
+	reader, events := readTrace(t, counts)
+	hits := map[string]uint64{}
+	for _, event := range events {
+		count, ok := event.(trace.Count)
+		if !ok {
+			t.Fatalf("unexpected %s record in counter mode", event.Kind())
+		}
+		point, ok := reader.Point(count.Point)
+		if !ok {
+			t.Fatalf("point %#x of count is not registered", count.Point)
+		}
+		hits[point.Path] += count.Hits
+	}
+	if len(hits) == 0 {
+		t.Fatal("no count records")
+	}
+	for path, n := range hits {
+		if n%3 != 0 {
+			t.Errorf("point %s is hit %d times, Sign is called 3 times", path, n)
+		}
+	}
+}